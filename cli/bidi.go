@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/state"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// bidiListIndex is the index of running bidirectional sync listing, keys of skipped errors are marked in it.
+var bidiListIndex *collection.ObjectIndex
+
+// runBidi run bidirectional sync between source and target storages and return the sync status.
+// Both sides are listed with metadata, compared with the state database and changes are applied in phases.
+// The state of the keys is updated only for succeeded changes, so failed changes are retried on the next run.
+func runBidi(ctx context.Context, cancel context.CancelFunc, sysStopChan chan os.Signal) syncStatus {
+	db, err := state.Open[collection.BidiState](cli.BidiState)
+	if err != nil {
+		log.Fatalf("Failed to open bidirectional sync state, error: %s", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Errorf("Failed to save bidirectional sync state, error: %s", err)
+		}
+	}()
+
+	syncGroup := pipeline.NewGroup()
+	if err := setupStorages(ctx, &syncGroup, &cli); err != nil {
+		log.Fatalf("Failed to setup storage, error: %s", err)
+	}
+	sides := map[collection.BidiSide]storage.Storage{
+		collection.BidiSource: syncGroup.Source,
+		collection.BidiTarget: syncGroup.Target,
+	}
+
+	log.Info("Starting bidirectional sync")
+	srcIndex := collection.NewObjectIndex()
+	dstIndex := collection.NewObjectIndex()
+	for side, index := range []*collection.ObjectIndex{collection.BidiSource: srcIndex, collection.BidiTarget: dstIndex} {
+		listGroup := pipeline.NewGroup()
+		listGroup.SetSource(sides[collection.BidiSide(side)])
+		listGroup.SetTarget(sides[collection.BidiSide(side)])
+		setupBidiListPipeline(&listGroup, index)
+		bidiListIndex = index
+		status := runGroup(ctx, cancel, sysStopChan, &listGroup)
+		bidiListIndex = nil
+		if status != syncStatusOk {
+			return status
+		}
+	}
+
+	plan, err := collection.BidiReconcile(&syncGroup, srcIndex, dstIndex, db, collection.BidiConfig{
+		Compare: collection.CompareConfig{
+			Comparator:     collection.Comparators[cli.Compare],
+			MtimeTolerance: cli.CompareMtimeTol,
+		},
+		Conflict:       cli.BidiConflict,
+		ConflictSuffix: cli.BidiConflictSuffix,
+		MaxDelete:      int(cli.BidiMaxDelete),
+	})
+	if err != nil {
+		log.Errorf("Bidirectional sync reconciliation failed, error: %s", err)
+		return syncStatusFailed
+	}
+	if len(plan.Conflicts) > 0 {
+		for _, conflict := range plan.Conflicts {
+			log.WithField("key", conflict.Key).Errorf("Conflict: %s", conflict.Reason)
+		}
+		log.Errorf("Found %d conflicts, no changes applied", len(plan.Conflicts))
+		return syncStatusFailed
+	}
+	log.WithFields(logrus.Fields{
+		"preserve": len(plan.Preserve),
+		"copy":     len(plan.Copy),
+		"delete":   len(plan.Delete),
+	}).Info("Bidirectional sync plan")
+
+	status := syncStatusOk
+	for _, phase := range [][]collection.BidiAction{plan.Preserve, plan.Copy, plan.Delete} {
+		if status = applyBidiActions(ctx, cancel, sysStopChan, sides, plan, phase); status != syncStatusOk {
+			break
+		}
+	}
+
+	updateBidiState(db, sides, plan, srcIndex, dstIndex)
+	return status
+}
+
+// applyBidiActions run a group for every pair of sides used by actions.
+func applyBidiActions(ctx context.Context, cancel context.CancelFunc, sysStopChan chan os.Signal,
+	sides map[collection.BidiSide]storage.Storage, plan *collection.BidiPlan, actions []collection.BidiAction) syncStatus {
+	type sidePair struct{ from, to collection.BidiSide }
+	configs := make(map[sidePair]collection.BidiApplyConfig)
+	objects := make(map[sidePair][]*storage.Object)
+	var pairs []sidePair
+
+	for _, action := range actions {
+		pair := sidePair{from: action.From, to: action.To}
+		if _, ok := configs[pair]; !ok {
+			configs[pair] = collection.BidiApplyConfig{Plan: plan, Actions: make(map[string]collection.BidiAction)}
+			pairs = append(pairs, pair)
+		}
+		key := action.Key
+		if action.Delete {
+			key = action.ToKey
+		}
+		configs[pair].Actions[key] = action
+		objects[pair] = append(objects[pair], &storage.Object{Key: storage.ToPtr(key)})
+	}
+
+	for _, pair := range pairs {
+		applyGroup := pipeline.NewGroup()
+		applyGroup.SetSource(sides[pair.from])
+		applyGroup.SetTarget(sides[pair.to])
+		applyGroup.SetObjectTimeout(cli.ObjectTimeout)
+		applyGroup.AddPipeStep(pipeline.NewStep("ListObjects", collection.ListObjects, objects[pair]))
+		applyGroup.AddPipeStep(pipeline.NewStep("ApplyBidiActions", collection.ApplyBidiActions, configs[pair]).WithWorkers(cli.Workers))
+		if cli.SyncLog {
+			applyGroup.AddPipeStep(pipeline.NewStep("Logger", collection.Logger, log))
+		}
+		applyGroup.AddPipeStep(pipeline.Step{
+			Name: "Terminator",
+			Fn:   collection.Terminator,
+		})
+		if status := runGroup(ctx, cancel, sysStopChan, &applyGroup); status != syncStatusOk {
+			return status
+		}
+	}
+	return syncStatusOk
+}
+
+// updateBidiState save state of the keys with succeeded changes, equal keys and forget deleted ones.
+func updateBidiState(db *state.DB[collection.BidiState], sides map[collection.BidiSide]storage.Storage,
+	plan *collection.BidiPlan, srcIndex, dstIndex *collection.ObjectIndex) {
+	for _, key := range plan.InSync {
+		srcObj, _ := srcIndex.Get(key)
+		dstObj, _ := dstIndex.Get(key)
+		putBidiState(db, key, srcObj, dstObj)
+	}
+	for _, key := range plan.Forget {
+		deleteBidiState(db, key)
+	}
+
+	for _, key := range plan.Completed() {
+		srcObj := &storage.Object{Key: storage.ToPtr(key)}
+		srcErr := sides[collection.BidiSource].GetObjectMeta(srcObj)
+		dstObj := &storage.Object{Key: storage.ToPtr(key)}
+		dstErr := sides[collection.BidiTarget].GetObjectMeta(dstObj)
+		switch {
+		case srcErr == nil && dstErr == nil:
+			putBidiState(db, key, srcObj, dstObj)
+		case storage.IsErrNotExist(srcErr) && storage.IsErrNotExist(dstErr):
+			deleteBidiState(db, key)
+		default:
+			log.WithField("key", key).Warnf("Failed to update bidirectional sync state, source error: %v, target error: %v", srcErr, dstErr)
+		}
+	}
+}
+
+func putBidiState(db *state.DB[collection.BidiState], key string, srcObj, dstObj *storage.Object) {
+	err := db.Put(key, collection.BidiState{Source: state.NewEntry(srcObj), Target: state.NewEntry(dstObj)})
+	if err != nil {
+		log.WithField("key", key).Errorf("Failed to save bidirectional sync state, error: %s", err)
+	}
+}
+
+func deleteBidiState(db *state.DB[collection.BidiState], key string) {
+	if err := db.Delete(key); err != nil {
+		log.WithField("key", key).Errorf("Failed to save bidirectional sync state, error: %s", err)
+	}
+}
+
+func setupBidiListPipeline(group *pipeline.Group, index *collection.ObjectIndex) {
+	group.SetObjectTimeout(cli.ObjectTimeout)
+	group.AddPipeStep(pipeline.Step{
+		Name:     "ListSource",
+		Fn:       collection.ListSourceStorage,
+		ChanSize: cli.ListBuffer,
+	})
+	group.AddPipeStep(pipeline.Step{
+		Name:       "LoadObjMeta",
+		Fn:         collection.LoadObjectMeta,
+		AddWorkers: cli.Workers,
+	})
+	group.AddPipeStep(pipeline.NewStep("IndexObj", collection.IndexObjects, index))
+	group.AddPipeStep(pipeline.Step{
+		Name: "Terminator",
+		Fn:   collection.Terminator,
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"os"
@@ -9,10 +10,30 @@ import (
 	"time"
 	"unicode"
 
+	"filippo.io/age"
 	"github.com/alexflint/go-arg"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/mattn/go-isatty"
 
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
 	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/storage/fs"
+)
+
+const keyFileLen = 32
+
+// mergePrimarySource is the name of the main SOURCE in the list of merged sources.
+const mergePrimarySource = "source"
+
+// fanOutPrimaryTarget is the name of the main TARGET in the list of fan-out targets.
+const fanOutPrimaryTarget = "target"
+
+// Modes of target objects lookup.
+const (
+	targetIndexAuto = "auto"
+	targetIndexList = "list"
+	targetIndexHead = "head"
 )
 
 var (
@@ -24,12 +45,47 @@ var (
 // Parsed CLI args with embedded fields
 type argsParsed struct {
 	args
-	Source             connect
-	Target             connect
-	S3RetryInterval    time.Duration
-	SwiftRetryInterval time.Duration
-	FSFilePerm         os.FileMode
-	FSDirPerm          os.FileMode
+	Source                 connect
+	Target                 connect
+	S3RetryInterval        time.Duration
+	SwiftRetryInterval     time.Duration
+	FSFilePerm             os.FileMode
+	FSDirPerm              os.FileMode
+	RateLimitBandwidth     int
+	ErrorHandlingMask      storage.ErrHandlingMask
+	S3Tags                 map[string]*string
+	S3ObjectLockPeriod     time.Duration
+	S3SourceSSECustomerKey string
+	S3TargetSSECustomerKey string
+	CSEKey                 []byte
+	CSERecipients          []age.Recipient
+	CSEIdentities          []age.Identity
+	RewriteKeyRules        []collection.KeyRewriteRule
+	CompareMtimeTol        time.Duration
+	ChecksumAlgorithms     []string
+	Verify                 bool
+	FanOutTargets          []fanOutTarget
+	MergeSources           []mergeSource
+	PipelineDefinition     *pipeline.Definition
+	ObjectTimeout          time.Duration
+}
+
+// mergeSource is the additional source merged into one listing.
+type mergeSource struct {
+	Name     string
+	Conn     connect
+	Region   string
+	Endpoint string
+	Prefix   string
+}
+
+// fanOutTarget is the additional target of fan-out upload.
+type fanOutTarget struct {
+	Name               string
+	Conn               connect
+	Region             string
+	Endpoint           string
+	RateLimitObjPerSec uint
 	RateLimitBandwidth int
 	ErrorHandlingMask  storage.ErrHandlingMask
 }
@@ -59,21 +115,54 @@ type args struct {
 	TargetRegion   string `arg:"--tr" help:"Target AWS Region"`
 	TargetEndpoint string `arg:"--te" help:"Target AWS Endpoint"`
 	// S3 config
-	S3Retry                uint   `arg:"--s3-retry" help:"Max numbers of retries to sync file"`
-	S3RetryInterval        uint   `arg:"--s3-retry-sleep" help:"Sleep interval (sec) between sync retries on error"`
-	S3Acl                  string `arg:"--s3-acl" help:"S3 ACL for uploaded files. Possible values: private, public-read, public-read-write, aws-exec-read, authenticated-read, bucket-owner-read, bucket-owner-full-control"`
-	S3CacheControl         string `arg:"--s3-cache-control" help:"Cache-Control header for uploaded files."`
-	S3StorageClass         string `arg:"--s3-storage-class" help:"S3 Storage Class for uploaded files."`
-	S3KeysPerReq           int64  `arg:"--s3-keys-per-req" help:"Max numbers of keys retrieved via List request" default:"1000"`
-	S3ServerSideEncryption string `arg:"--s3-sse" help:"Use server-side encryption, if specified valid options are \"AES256\" and \"aws:kms\"."`
+	S3Retry                uint     `arg:"--s3-retry" help:"Max numbers of retries to sync file"`
+	S3RetryInterval        uint     `arg:"--s3-retry-sleep" help:"Sleep interval (sec) between sync retries on error"`
+	S3Acl                  string   `arg:"--s3-acl" help:"S3 ACL for uploaded files. Possible values: private, public-read, public-read-write, aws-exec-read, authenticated-read, bucket-owner-read, bucket-owner-full-control"`
+	S3CacheControl         string   `arg:"--s3-cache-control" help:"Cache-Control header for uploaded files."`
+	S3StorageClass         string   `arg:"--s3-storage-class" help:"S3 Storage Class for uploaded files."`
+	S3KeysPerReq           int64    `arg:"--s3-keys-per-req" help:"Max numbers of keys retrieved via List request" default:"1000"`
+	S3ServerSideEncryption string   `arg:"--s3-sse" help:"Use server-side encryption, if specified valid options are \"AES256\" and \"aws:kms\"."`
+	S3SSEKMSKeyId          string   `arg:"--s3-sse-kms-key-id" help:"KMS key ID or ARN for SSE-KMS encryption of uploaded files. Implies --s3-sse aws:kms"`
+	S3SSEBucketKey         bool     `arg:"--s3-sse-bucket-key" help:"Enable S3 Bucket Key for SSE-KMS encryption of uploaded files"`
+	S3SourceSSECKeyFile    string   `arg:"--s3-source-sse-c-key-file" help:"Path to file with SSE-C key (32 bytes, raw or base64 encoded) of source objects"`
+	S3TargetSSECKeyFile    string   `arg:"--s3-target-sse-c-key-file" help:"Path to file with SSE-C key (32 bytes, raw or base64 encoded) for uploaded files"`
+	S3Tags                 []string `arg:"--s3-tags,separate" help:"Set tags for uploaded files in key=value format. Can be specified multiple times"`
+	S3CopyTags             bool     `arg:"--s3-copy-tags" help:"Copy tags of source S3 objects"`
+	S3CopyObjectLock       bool     `arg:"--s3-copy-object-lock" help:"Load retention and legal hold of source S3 objects with separate requests"`
+	S3ObjectLockMode       string   `arg:"--s3-object-lock-mode" help:"Object Lock retention mode for uploaded files. Possible values: GOVERNANCE, COMPLIANCE"`
+	S3ObjectLockDays       uint     `arg:"--s3-object-lock-days" help:"Object Lock retention period (days) for uploaded files"`
+	S3ObjectLockLegalHold  string   `arg:"--s3-object-lock-legal-hold" help:"Object Lock legal hold status for uploaded files. Possible values: ON, OFF"`
 	// FS config
-	FSFilePerm     string `arg:"--fs-file-perm" help:"File permissions" default:"0644"`
-	FSDirPerm      string `arg:"--fs-dir-perm" help:"Dir permissions" default:"0755"`
-	FSDisableXattr bool   `arg:"--fs-disable-xattr" help:"Disable FS xattr for storing metadata"`
-	FSAtomicWrite  bool   `arg:"--fs-atomic-write" help:"Enable FS atomic writes. New files will be written to temp file and renamed"`
+	FSFilePerm      string `arg:"--fs-file-perm" help:"File permissions" default:"0644"`
+	FSDirPerm       string `arg:"--fs-dir-perm" help:"Dir permissions" default:"0755"`
+	FSDisableXattr  bool   `arg:"--fs-disable-xattr" help:"Disable FS xattr for storing metadata"`
+	FSAtomicWrite   bool   `arg:"--fs-atomic-write" help:"Enable FS atomic writes. New files will be written to temp file and renamed"`
+	FSEncodeKeys    bool   `arg:"--fs-encode-keys" help:"Encode object keys to FS-safe paths (percent-encoding). Use it for both directions to get the same keys"`
+	FSPreserveAttrs bool   `arg:"--fs-preserve-attrs" help:"Store file mtime, mode, uid and gid in object metadata and restore it on FS target"`
+	FSPreserveAtime bool   `arg:"--fs-preserve-atime" help:"Store and restore file atime too, requires --fs-preserve-attrs"`
+	FSSidecarMeta   bool   `arg:"--fs-sidecar-meta" help:"Store metadata in sidecar files in .s3sync-meta dir instead of xattr. Use it on filesystems without xattr support"`
+	FSETag          bool   `arg:"--fs-etag" help:"Compute S3 compatible ETag for files without stored ETag. Computed ETags are cached in xattr or sidecar metadata"`
+	FSETagPartSize  int64  `arg:"--fs-etag-part-size" help:"Part size (bytes) for multipart ETag computation of large files. 0 means plain MD5, use 5242880 to match s3-stream uploads"`
+	FSSymlinks      string `arg:"--fs-symlinks" help:"Symlinks handling mode. Possible values: follow, skip, preserve. Preserve store symlink target as object content and restore symlinks on FS target, symlinks pointing outside of target dir are rejected" default:"follow"`
 	// Swift config
 	SwiftRetry         uint `arg:"--swift-retry" help:"Max numbers of retries to sync file"`
 	SwiftRetryInterval uint `arg:"--swift-retry-sleep" help:"Sleep interval (sec) between sync retries on error"`
+	// Client-side encryption
+	CSEMode         string   `arg:"--cse" help:"Client-side encryption of files content. Possible values: encrypt, decrypt"`
+	CSEKeyFile      string   `arg:"--cse-key-file" help:"Path to file with client-side encryption master key (32 bytes, raw or base64 encoded)"`
+	CSERecipients   []string `arg:"--cse-recipient,separate" help:"age recipient (public key) for client-side encryption. Can be specified multiple times"`
+	CSEIdentityFile string   `arg:"--cse-identity-file" help:"Path to file with age identities for client-side decryption"`
+	// Checksums
+	Checksum []string `arg:"--checksum,separate" help:"Compute content checksum, verify it against source checksum and send it to target (S3 x-amz-checksum-* header or FS metadata). Possible values: crc32c, sha256. Can be specified multiple times"`
+	// Compression
+	Compress        string   `arg:"--compress" help:"Compress files content. Possible values: gzip, zstd"`
+	Decompress      bool     `arg:"--decompress" help:"Decompress files with gzip or zstd Content-Encoding"`
+	CompressRename  bool     `arg:"--compress-rename" help:"Add .gz/.zst extension to compressed files instead of setting Content-Encoding. With --decompress, decompress files with this extensions and remove it"`
+	CompressMinSize int64    `arg:"--compress-min-size" help:"Compress only files larger than given size (bytes)"`
+	CompressCT      []string `arg:"--compress-ct,separate" help:"Compress only files with given Content-Type, wildcard suffix allowed (text/*). Can be specified multiple times"`
+	// Key rewriting
+	RewriteKey               []string `arg:"--rewrite-key,separate" help:"Rewrite keys with rule in REGEX=REPLACEMENT format, rules are applied in order. REPLACEMENT can contain submatches ($1) or be a Go template ({{ lower .Key }}) producing the whole key. Can be specified multiple times"`
+	RewriteKeySkipCollisions bool     `arg:"--rewrite-key-skip-collision-check" help:"Do not check that different keys are rewritten to the same key"`
 	// Filters
 	FilterExt         []string `arg:"--filter-ext,separate" help:"Sync only files with given extensions"`
 	FilterExtNot      []string `arg:"--filter-not-ext,separate" help:"Skip files with given extensions"`
@@ -82,10 +171,42 @@ type args struct {
 	FilterMtimeAfter  int64    `arg:"--filter-after-mtime" help:"Sync only files modified after given unix timestamp"`
 	FilterMtimeBefore int64    `arg:"--filter-before-mtime" help:"Sync only files modified before given unix timestamp"`
 	FilterModified    bool     `arg:"--filter-modified" help:"Sync only modified files"`
+	Compare           string   `arg:"--compare" help:"Modified files detection mode for --filter-modified. Possible values: etag, size, mtime, size+mtime, checksum, content. Files without stored checksums are always modified in checksum mode, content mode reads both files" default:"etag"`
+	CompareMtimeTol   uint     `arg:"--compare-mtime-tolerance" help:"Max mtime difference (msec) that considered equal by mtime comparison" default:"1000"`
+	CompareNewerOnly  bool     `arg:"--compare-newer-only" help:"Do not overwrite target files that are newer than source ones. Original mtime from metadata is used if it stored (--fs-preserve-attrs)"`
 	FilterExist       bool     `arg:"--filter-exist" help:"Sync only files, that exist in target storage"`
 	FilterExistNot    bool     `arg:"--filter-not-exist" help:"Sync only files, that doesn't exist in target storage"`
 	FilterDirs        bool     `arg:"--filter-dirs" help:"Sync only files, that ends with slash (/)"`
 	FilterDirsNot     bool     `arg:"--filter-not-dirs" help:"Skip files that ends with slash (/)"`
+	// Target lookup
+	TargetIndex           string `arg:"--target-index" help:"Look up target objects for --filter-modified, --filter-exist and --filter-not-exist in the target listing instead of per-object requests. S3 targets are listed with --workers concurrent requests by top level dirs. Possible values: auto, list, head" default:"auto"`
+	TargetIndexThreshold  uint64 `arg:"--target-index-threshold" help:"Number of per-object target requests before the target listing is used in auto mode. The target is listed regardless of its size, use a higher value or head mode for small syncs to a large target" default:"10000"`
+	TargetIndexMaxObjects uint64 `arg:"--target-index-max-objects" help:"Max number of target objects kept in memory by the target listing (about 0.5KB per object), larger listings are spilled to disk. 0 means no limit" default:"5000000"`
+	TargetIndexSpillDir   string `arg:"--target-index-spill-dir" help:"Dir for the target listing spilled to disk. Default is the system temp dir"`
+	// Verify
+	VerifyReport       string `arg:"--verify-report" help:"Path to report file of verify command, - for stdout" default:"-"`
+	VerifyReportFormat string `arg:"--verify-report-format" help:"Format of verify command report. Possible values: json, csv" default:"json"`
+	// Move
+	Move       bool `arg:"--move" help:"Delete source objects after successful upload"`
+	MoveVerify bool `arg:"--move-verify" help:"Check target object size and checksums before deleting the source object"`
+	// State
+	StateDB string `arg:"--state-db" help:"Path to the local state database. Objects that are not changed since the last successful upload are skipped without requests to the target. Changes of target objects made by other tools are not detected"`
+	// Bidirectional sync
+	BidiState          string `arg:"--bidi-state" help:"Enable bidirectional sync with state database in given file. Creates, updates and deletes are propagated in both directions. Objects skipped by --error-handling are not changed, sync is aborted if a side is listed empty while the state is not empty"`
+	BidiConflict       string `arg:"--bidi-conflict" help:"Conflict resolution of bidirectional sync. Possible values: newer, keep-both, fail" default:"fail"`
+	BidiConflictSuffix string `arg:"--bidi-conflict-suffix" help:"Key suffix of the older object with keep-both conflict resolution" default:".conflict"`
+	BidiMaxDelete      uint   `arg:"--bidi-max-delete" help:"Max number of objects deleted by bidirectional sync, no changes are applied if more objects would be deleted. 0 means no limit"`
+	// Merge sources
+	MergeSource       []string `arg:"--merge-source,separate" help:"List objects from additional source of the same storage type in NAME=URL format, the main SOURCE is named \"source\". URL query can set region, endpoint and prefix of target keys of the source (s3://bucket/path?prefix=team-a/). Can be specified multiple times. Sources are listed in order, objects with the key already listed from SOURCE or previous merged source fail with conflict error"`
+	MergeSourcePrefix string   `arg:"--merge-source-prefix" help:"Prefix of target keys of the main SOURCE objects with --merge-source"`
+	// Fan-out
+	FanOutTarget []string `arg:"--fanout-target,separate" help:"Upload objects to additional target in NAME=URL format, the main TARGET is named \"target\". URL query can set region, endpoint, ratelimit-objects, ratelimit-bandwidth and error-handling of the target (s3://bucket/path?region=eu-west-1). Can be specified multiple times"`
+	FanOutSelect []string `arg:"--fanout-select,separate" help:"Upload objects only to targets with given names. Can be specified multiple times"`
+	// Pipeline definition
+	Pipeline string `arg:"--pipeline" help:"Path to YAML or JSON (.json extension) pipeline definition file. Pipeline steps are created from the definition, flags that create steps (filters, transformations, --move, --sync-log) are not allowed"`
+	// Dry run
+	DryRun     bool   `arg:"--dry-run" help:"List and filter objects, but do not upload it. Print the plan of uploads, overwrites and deletions"`
+	DryRunPlan string `arg:"--dry-run-plan" help:"Path to JSON file for dry run plan, - for stdout"`
 	// Misc
 	Workers           uint   `arg:"-w" help:"Workers count" default:"16"`
 	Debug             bool   `arg:"-d" help:"Show debug logging"`
@@ -93,12 +214,13 @@ type args struct {
 	SyncLogFormat     string `arg:"--sync-log-format" help:"Format of sync log. Possible values: json"`
 	ShowProgress      bool   `arg:"--sync-progress,-p" help:"Show sync progress"`
 	OnFail            string `arg:"--on-fail,-f" help:"Action on failed. Possible values: fatal, skip, skipmissing (DEPRECATED, use --error-handling instead)" default:"fatal"`
-	ErrorHandlingMask uint8  `arg:"--error-handling" help:"Controls error handling. Sum of the values: 1 for ignoring NotFound errors, 2 for ignoring PermissionDenied errors OR 255 to ignore all errors"`
+	ErrorHandlingMask uint8  `arg:"--error-handling" help:"Controls error handling. Sum of the values: 1 for ignoring NotFound errors, 2 for ignoring PermissionDenied errors, 4 for ignoring checksum mismatch errors, 64 for ignoring other errors OR 255 to ignore all errors"`
 	DisableHTTP2      bool   `arg:"--disable-http2" help:"Disable HTTP2 for http client"`
 	ListBuffer        uint   `arg:"--list-buffer" help:"Size of list buffer" default:"1000"`
 	SkipSSLVerify     bool   `arg:"--skip-ssl-verify" help:"Disable SSL verification for S3"`
 	ServerGzip        bool   `arg:"--server-gzip" help:"Workaround for S3 servers with enabled gzip compression for all files."`
 	Profiler          bool   `arg:"--profiler" help:"Enable profiler on :8080"`
+	ObjectTimeout     uint   `arg:"--object-timeout" help:"Timeout (sec) of processing of one object by each pipeline step. Applied to S3 requests, 0 means no timeout"`
 	// Rate Limit
 	RateLimitObjPerSec uint   `arg:"--ratelimit-objects" help:"Rate limit objects per second"`
 	RateLimitBandwidth string `arg:"--ratelimit-bandwidth" help:"Set bandwidth rate limit, byte/s, Allow suffixes: K, M, G"`
@@ -111,14 +233,33 @@ func (args) Version() string {
 
 // Description return program description string
 func (args) Description() string {
-	return "Really fast sync tool for S3"
+	return "Really fast sync tool for S3\n" +
+		"Use \"s3sync verify SOURCE TARGET\" to compare storages and report missing, extra and modified objects"
 }
 
 // GetCliArgs parse cli args, set default values, check input values and return argsParsed struct
 func GetCliArgs() (cli argsParsed, err error) {
 	rawCli := args{}
 
-	p := arg.MustParse(&rawCli)
+	argv := os.Args[1:]
+	if len(argv) > 0 && argv[0] == verifyCommand {
+		cli.Verify = true
+		argv = argv[1:]
+	}
+	p, err := arg.NewParser(arg.Config{}, &rawCli)
+	if err != nil {
+		return cli, err
+	}
+	switch err := p.Parse(argv); {
+	case err == arg.ErrHelp:
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	case err == arg.ErrVersion:
+		fmt.Println(rawCli.Version())
+		os.Exit(0)
+	case err != nil:
+		p.Fail(err.Error())
+	}
 	cli.args = rawCli
 
 	cli.args.S3Acl = strings.ToLower(cli.args.S3Acl)
@@ -146,6 +287,128 @@ func GetCliArgs() (cli argsParsed, err error) {
 		p.Fail("--s3-sse must be one of \"\", \"AES256\" or \"aws:kms\"")
 	}
 
+	if cli.args.S3SSEKMSKeyId != "" && cli.args.S3ServerSideEncryption == "AES256" {
+		p.Fail("--s3-sse-kms-key-id can be used only with \"aws:kms\" server-side encryption")
+	}
+	if cli.args.S3SSEBucketKey && cli.args.S3SSEKMSKeyId == "" && cli.args.S3ServerSideEncryption != "aws:kms" {
+		p.Fail("--s3-sse-bucket-key can be used only with \"aws:kms\" server-side encryption")
+	}
+
+	if cli.args.S3SourceSSECKeyFile != "" {
+		key, err := readKeyFile(cli.args.S3SourceSSECKeyFile)
+		if err != nil {
+			return cli, err
+		}
+		cli.S3SourceSSECustomerKey = string(key)
+	}
+	if cli.args.S3TargetSSECKeyFile != "" {
+		if cli.args.S3ServerSideEncryption != "" || cli.args.S3SSEKMSKeyId != "" {
+			p.Fail("--s3-target-sse-c-key-file can't be used with --s3-sse or --s3-sse-kms-key-id")
+		}
+		key, err := readKeyFile(cli.args.S3TargetSSECKeyFile)
+		if err != nil {
+			return cli, err
+		}
+		cli.S3TargetSSECustomerKey = string(key)
+	}
+
+	if cli.args.CSEKeyFile != "" {
+		if cli.CSEKey, err = readKeyFile(cli.args.CSEKeyFile); err != nil {
+			return cli, err
+		}
+	}
+	for _, r := range cli.args.CSERecipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return cli, err
+		}
+		cli.CSERecipients = append(cli.CSERecipients, recipient)
+	}
+	if cli.args.CSEIdentityFile != "" {
+		f, err := os.Open(cli.args.CSEIdentityFile)
+		if err != nil {
+			return cli, err
+		}
+		cli.CSEIdentities, err = age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return cli, err
+		}
+	}
+	switch cli.args.CSEMode {
+	case "":
+	case "encrypt":
+		if cli.CSEKey == nil && len(cli.CSERecipients) == 0 {
+			p.Fail("--cse encrypt require --cse-key-file or --cse-recipient")
+		}
+	case "decrypt":
+		if cli.CSEKey == nil && len(cli.CSEIdentities) == 0 {
+			p.Fail("--cse decrypt require --cse-key-file or --cse-identity-file")
+		}
+	default:
+		p.Fail("--cse must be one of \"encrypt, decrypt\"")
+	}
+
+	for _, alg := range cli.Checksum {
+		alg = strings.ToUpper(alg)
+		if _, err := storage.NewChecksumHash(alg); err != nil {
+			p.Fail("--checksum must be one of \"crc32c, sha256\"")
+		}
+		cli.ChecksumAlgorithms = append(cli.ChecksumAlgorithms, alg)
+	}
+
+	switch cli.args.Compress {
+	case "":
+	case "gzip", "zstd":
+		if cli.args.Decompress {
+			p.Fail("--compress can't be used with --decompress")
+		}
+	default:
+		p.Fail("--compress must be one of \"gzip, zstd\"")
+	}
+
+	for _, r := range cli.args.RewriteKey {
+		rule, err := collection.ParseKeyRewriteRule(r)
+		if err != nil {
+			p.Fail(fmt.Sprintf("Invalid value of (--rewrite-key) arg: %s", err))
+		}
+		cli.RewriteKeyRules = append(cli.RewriteKeyRules, rule)
+	}
+	if len(cli.RewriteKeyRules) > 0 && (cli.args.FilterModified || cli.args.FilterExist || cli.args.FilterExistNot) {
+		p.Fail("--rewrite-key can't be used with --filter-modified, --filter-exist and --filter-not-exist")
+	}
+
+	cli.S3Tags = make(map[string]*string, len(cli.args.S3Tags))
+	for _, tag := range cli.args.S3Tags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			p.Fail("--s3-tags must be in \"key=value\" format")
+		}
+		cli.S3Tags[kv[0]] = storage.ToPtr(kv[1])
+	}
+
+	cli.args.S3ObjectLockMode = strings.ToUpper(cli.args.S3ObjectLockMode)
+	switch cli.args.S3ObjectLockMode {
+	case "":
+		if cli.args.S3ObjectLockDays > 0 {
+			p.Fail("--s3-object-lock-days require --s3-object-lock-mode")
+		}
+	case s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance:
+		if cli.args.S3ObjectLockDays == 0 {
+			p.Fail("--s3-object-lock-mode require --s3-object-lock-days")
+		}
+	default:
+		p.Fail("--s3-object-lock-mode must be one of \"GOVERNANCE, COMPLIANCE\"")
+	}
+	cli.S3ObjectLockPeriod = time.Duration(cli.args.S3ObjectLockDays) * 24 * time.Hour
+
+	cli.args.S3ObjectLockLegalHold = strings.ToUpper(cli.args.S3ObjectLockLegalHold)
+	switch cli.args.S3ObjectLockLegalHold {
+	case "", s3.ObjectLockLegalHoldStatusOn, s3.ObjectLockLegalHoldStatusOff:
+	default:
+		p.Fail("--s3-object-lock-legal-hold must be one of \"ON, OFF\"")
+	}
+
 	cli.ErrorHandlingMask = storage.ErrHandlingMask(cli.args.ErrorHandlingMask)
 	switch cli.args.OnFail {
 	case "fatal":
@@ -196,8 +459,147 @@ func GetCliArgs() (cli argsParsed, err error) {
 		_ = os.Setenv("GODEBUG", os.Getenv("GODEBUG")+"http2client=0")
 	}
 
-	if cli.FilterModified && cli.FSDisableXattr {
-		p.Fail("Filter modified files (--filter-modified) required xattr")
+	switch cli.FSSymlinks {
+	case fs.SymlinksFollow, fs.SymlinksSkip, fs.SymlinksPreserve:
+	default:
+		p.Fail("--fs-symlinks must be one of \"follow, skip, preserve\"")
+	}
+
+	if cli.FSPreserveAtime && !cli.FSPreserveAttrs {
+		p.Fail("--fs-preserve-atime require --fs-preserve-attrs")
+	}
+
+	if _, ok := collection.Comparators[cli.Compare]; !ok {
+		p.Fail("--compare must be one of \"etag, size, mtime, size+mtime, checksum, content\"")
+	}
+	if (cli.Compare != collection.CompareETag || cli.CompareNewerOnly) && !cli.FilterModified && !cli.Verify && cli.BidiState == "" {
+		p.Fail("--compare and --compare-newer-only require --filter-modified")
+	}
+	cli.CompareMtimeTol = time.Duration(cli.args.CompareMtimeTol) * time.Millisecond
+	cli.ObjectTimeout = time.Duration(cli.args.ObjectTimeout) * time.Second
+
+	if cli.FilterModified && cli.Compare == collection.CompareETag && cli.FSDisableXattr && !cli.FSSidecarMeta && !cli.FSETag {
+		p.Fail("Filter modified files (--filter-modified) required xattr, sidecar metadata (--fs-sidecar-meta) or ETag computation (--fs-etag)")
+	}
+
+	if cli.MoveVerify && !cli.Move {
+		p.Fail("--move-verify require --move")
+	}
+	if cli.Move && cli.Verify {
+		p.Fail("--move can't be used with verify command")
+	}
+
+	if cli.BidiState != "" {
+		switch cli.BidiConflict {
+		case collection.BidiConflictNewer, collection.BidiConflictKeepBoth, collection.BidiConflictFail:
+		default:
+			p.Fail("--bidi-conflict must be one of \"newer, keep-both, fail\"")
+		}
+		if cli.BidiConflict == collection.BidiConflictKeepBoth && cli.BidiConflictSuffix == "" {
+			p.Fail("--bidi-conflict-suffix can't be empty")
+		}
+		if cli.Verify || cli.Move || cli.DryRun || cli.StateDB != "" {
+			p.Fail("--bidi-state can't be used with verify command, --move, --dry-run or --state-db")
+		}
+		if len(cli.RewriteKey) > 0 || cli.Compress != "" || cli.Decompress || cli.CSEMode != "" {
+			p.Fail("--bidi-state can't be used with key rewriting, compression or client-side encryption")
+		}
+		// Stored metadata contains mtime of the original object, actual file mtime is required to detect changes.
+		fsMeta := !cli.FSDisableXattr || cli.FSSidecarMeta
+		if (cli.Source.Type == storage.TypeFS || cli.Target.Type == storage.TypeFS) && fsMeta && !cli.FSPreserveAttrs {
+			p.Fail("--bidi-state with FS storage require --fs-preserve-attrs or --fs-disable-xattr")
+		}
+	}
+
+	switch cli.TargetIndex {
+	case targetIndexAuto, targetIndexList, targetIndexHead:
+	default:
+		p.Fail("--target-index must be one of \"auto, list, head\"")
+	}
+
+	if cli.StateDB != "" && cli.Verify {
+		p.Fail("--state-db can't be used with verify command")
+	}
+	// Verify compare all source objects with target objects of the same keys.
+	if flags := cli.objectFlags(); len(flags) > 0 && cli.Verify {
+		p.Fail(fmt.Sprintf("verify command can't be used with %s", strings.Join(flags, ", ")))
+	}
+
+	if cli.DryRunPlan != "" && !cli.DryRun {
+		p.Fail("--dry-run-plan require --dry-run")
+	}
+
+	for _, src := range cli.args.MergeSource {
+		source, err := parseMergeSource(src)
+		if err != nil {
+			p.Fail(fmt.Sprintf("Invalid value of (--merge-source) arg: %s", err))
+		}
+		if source.Name == mergePrimarySource || cli.hasMergeSource(source.Name) {
+			p.Fail(fmt.Sprintf("--merge-source name %q is not unique", source.Name))
+		}
+		if source.Conn.Type != cli.Source.Type {
+			p.Fail(fmt.Sprintf("--merge-source %q must have the same storage type as SOURCE", source.Name))
+		}
+		cli.MergeSources = append(cli.MergeSources, source)
+	}
+	if len(cli.MergeSources) > 0 {
+		if cli.Verify || cli.BidiState != "" || cli.Move {
+			p.Fail("--merge-source can't be used with verify command, --bidi-state or --move")
+		}
+	} else if cli.MergeSourcePrefix != "" {
+		p.Fail("--merge-source-prefix require --merge-source")
+	}
+
+	for _, t := range cli.args.FanOutTarget {
+		target, err := parseFanOutTarget(t, cli.ErrorHandlingMask)
+		if err != nil {
+			p.Fail(fmt.Sprintf("Invalid value of (--fanout-target) arg: %s", err))
+		}
+		if target.Name == fanOutPrimaryTarget || cli.hasFanOutTarget(target.Name) {
+			p.Fail(fmt.Sprintf("--fanout-target name %q is not unique", target.Name))
+		}
+		cli.FanOutTargets = append(cli.FanOutTargets, target)
+	}
+	for _, name := range cli.args.FanOutSelect {
+		if name != fanOutPrimaryTarget && !cli.hasFanOutTarget(name) {
+			p.Fail(fmt.Sprintf("--fanout-select target %q is not found", name))
+		}
+	}
+	if len(cli.FanOutTargets) > 0 {
+		if cli.Verify || cli.BidiState != "" || cli.DryRun {
+			p.Fail("--fanout-target can't be used with verify command, --bidi-state or --dry-run")
+		}
+		if cli.MoveVerify {
+			p.Fail("--move-verify can't be used with --fanout-target")
+		}
+		// Filters check only the main target, so objects missing in other targets would be skipped.
+		if cli.FilterModified || cli.FilterExist || cli.FilterExistNot {
+			p.Fail("--fanout-target can't be used with --filter-modified, --filter-exist and --filter-not-exist")
+		}
+	} else if len(cli.args.FanOutSelect) > 0 {
+		p.Fail("--fanout-select require --fanout-target")
+	}
+
+	if cli.args.Pipeline != "" {
+		if cli.Verify || cli.BidiState != "" || cli.DryRun || cli.StateDB != "" {
+			p.Fail("--pipeline can't be used with verify command, --bidi-state, --dry-run or --state-db")
+		}
+		if cli.PipelineDefinition, err = pipeline.LoadDefinition(cli.args.Pipeline); err != nil {
+			p.Fail(fmt.Sprintf("Invalid value of (--pipeline) arg: %s", err))
+		}
+		if flags := cli.pipelineIgnoredFlags(); len(flags) > 0 {
+			p.Fail(fmt.Sprintf("--pipeline can't be used with %s, configure its steps in the pipeline definition", strings.Join(flags, ", ")))
+		}
+	}
+
+	switch cli.VerifyReportFormat {
+	case verifyReportJSON, verifyReportCSV:
+	default:
+		p.Fail("--verify-report-format must be one of \"json, csv\"")
+	}
+
+	if cli.FSETagPartSize < 0 {
+		p.Fail("--fs-etag-part-size must be positive")
 	}
 
 	return
@@ -232,6 +634,183 @@ func parseConn(cStr string) (conn connect, err error) {
 	return
 }
 
+// parseFanOutTarget parse fan-out target in NAME=URL format.
+// URL query parameters override target settings, errorMask is the default error handling of the target.
+func parseFanOutTarget(s string, errorMask storage.ErrHandlingMask) (target fanOutTarget, err error) {
+	var query url.Values
+	if target.Name, target.Conn, query, err = parseNamedConn(s); err != nil {
+		return target, err
+	}
+	target.ErrorHandlingMask = errorMask
+
+	for key := range query {
+		value := query.Get(key)
+		switch key {
+		case "region":
+			target.Region = value
+		case "endpoint":
+			target.Endpoint = value
+		case "ratelimit-objects":
+			rate, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return target, fmt.Errorf("invalid ratelimit-objects: %s", value)
+			}
+			target.RateLimitObjPerSec = uint(rate)
+		case "ratelimit-bandwidth":
+			rate, ok := parseBandwith(value)
+			if !ok {
+				return target, fmt.Errorf("invalid ratelimit-bandwidth: %s", value)
+			}
+			target.RateLimitBandwidth = rate
+		case "error-handling":
+			mask, err := strconv.ParseUint(value, 10, 8)
+			if err != nil {
+				return target, fmt.Errorf("invalid error-handling: %s", value)
+			}
+			target.ErrorHandlingMask = storage.ErrHandlingMask(mask)
+		default:
+			return target, fmt.Errorf("unknown parameter: %s", key)
+		}
+	}
+	return target, nil
+}
+
+// parseMergeSource parse merged source in NAME=URL format.
+// URL query parameters override source settings.
+func parseMergeSource(s string) (source mergeSource, err error) {
+	var query url.Values
+	if source.Name, source.Conn, query, err = parseNamedConn(s); err != nil {
+		return source, err
+	}
+
+	for key := range query {
+		value := query.Get(key)
+		switch key {
+		case "region":
+			source.Region = value
+		case "endpoint":
+			source.Endpoint = value
+		case "prefix":
+			source.Prefix = value
+		default:
+			return source, fmt.Errorf("unknown parameter: %s", key)
+		}
+	}
+	return source, nil
+}
+
+// parseNamedConn parse named storage in NAME=URL format and return the name, connection and URL query parameters.
+func parseNamedConn(s string) (name string, conn connect, query url.Values, err error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return name, conn, query, fmt.Errorf("storage must be in \"NAME=URL\" format")
+	}
+
+	connStr, rawQuery, _ := strings.Cut(parts[1], "?")
+	if conn, err = parseConn(connStr); err != nil {
+		return name, conn, query, err
+	}
+	query, err = url.ParseQuery(rawQuery)
+	return parts[0], conn, query, err
+}
+
+// hasMergeSource check that merged source with given name exists.
+func (cli *argsParsed) hasMergeSource(name string) bool {
+	for _, source := range cli.MergeSources {
+		if source.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pipelineIgnoredFlags return flags that create pipeline steps and are ignored with pipeline definition.
+// Merged sources and fan-out targets are used only by ListMergedSources and UploadObjectDataFanOut steps of the definition.
+func (cli *argsParsed) pipelineIgnoredFlags() []string {
+	flags := cli.objectFlags()
+	add := func(set bool, flag string) {
+		if set {
+			flags = append(flags, flag)
+		}
+	}
+	add(len(cli.MergeSources) > 0 && !cli.PipelineDefinition.HasStep("ListMergedSources"), "--merge-source")
+	add(len(cli.FanOutTargets) > 0 && !cli.PipelineDefinition.HasStep("UploadObjectDataFanOut"), "--fanout-target")
+	add(len(cli.args.FanOutSelect) > 0, "--fanout-select")
+	add(cli.Move, "--move")
+	add(cli.MoveVerify, "--move-verify")
+	add(cli.SyncLog, "--sync-log")
+	add(cli.RateLimitObjPerSec > 0, "--ratelimit-objects")
+	add(len(cli.Checksum) > 0, "--checksum")
+	add(cli.S3Acl != "", "--s3-acl")
+	add(cli.S3CacheControl != "", "--s3-cache-control")
+	add(cli.S3StorageClass != "", "--s3-storage-class")
+	add(cli.S3ServerSideEncryption != "", "--s3-sse")
+	add(len(cli.S3Tags) > 0, "--s3-tags")
+	add(cli.S3CopyTags, "--s3-copy-tags")
+	add(cli.S3CopyObjectLock, "--s3-copy-object-lock")
+	add(cli.S3ObjectLockMode != "", "--s3-object-lock-mode")
+	add(cli.S3ObjectLockLegalHold != "", "--s3-object-lock-legal-hold")
+	return flags
+}
+
+// objectFlags return used flags of filters and transformations, which change the set of synced objects,
+// its target keys or content.
+func (cli *argsParsed) objectFlags() []string {
+	var flags []string
+	add := func(set bool, flag string) {
+		if set {
+			flags = append(flags, flag)
+		}
+	}
+	add(len(cli.FilterExt) > 0, "--filter-ext")
+	add(len(cli.FilterExtNot) > 0, "--filter-not-ext")
+	add(len(cli.FilterCT) > 0, "--filter-ct")
+	add(len(cli.FilterCTNot) > 0, "--filter-not-ct")
+	add(cli.FilterMtimeAfter > 0, "--filter-after-mtime")
+	add(cli.FilterMtimeBefore > 0, "--filter-before-mtime")
+	add(cli.FilterModified, "--filter-modified")
+	add(cli.FilterExist, "--filter-exist")
+	add(cli.FilterExistNot, "--filter-not-exist")
+	add(cli.FilterDirs, "--filter-dirs")
+	add(cli.FilterDirsNot, "--filter-not-dirs")
+	add(cli.Compress != "", "--compress")
+	add(cli.Decompress, "--decompress")
+	add(cli.CompressRename, "--compress-rename")
+	add(cli.CompressMinSize > 0, "--compress-min-size")
+	add(len(cli.CompressCT) > 0, "--compress-ct")
+	add(len(cli.RewriteKey) > 0, "--rewrite-key")
+	add(cli.CSEMode != "", "--cse")
+	return flags
+}
+
+// hasFanOutTarget check that fan-out target with given name exists.
+func (cli *argsParsed) hasFanOutTarget(name string) bool {
+	for _, target := range cli.FanOutTargets {
+		if target.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readKeyFile read 256 bit key from file.
+// File should contain key as raw bytes or in base64 encoding.
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == keyFileLen {
+		return data, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != keyFileLen {
+		return nil, fmt.Errorf("key file %s must contain %d bytes key, raw or base64 encoded", path, keyFileLen)
+	}
+	return key, nil
+}
+
 func parseBandwith(s string) (int, bool) {
 	if s == "" {
 		return 0, true
@@ -15,6 +15,8 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/state"
 	"github.com/larrabee/s3sync/storage"
 )
 
@@ -22,6 +24,9 @@ var cli argsParsed
 var log = logrus.New()
 var live *uilive.Writer
 
+// syncState is the state of synced objects, it is set if --state-db is used.
+var syncState *collection.StateConfig
+
 const (
 	goThreadsPerCPU = 8
 )
@@ -34,6 +39,7 @@ const (
 	syncStatusFailed
 	syncStatusAborted
 	syncStatusConfError
+	syncStatusMismatch
 )
 
 // init program runtime: parse cli args and set logger
@@ -72,13 +78,44 @@ func main() {
 	sysStopChan := make(chan os.Signal, 1)
 	signal.Notify(sysStopChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
+	if cli.Verify {
+		verifyStatus := runVerify(ctx, cancel, sysStopChan)
+		printStatus(verifyStatus)
+		log.Exit(int(verifyStatus))
+	}
+	if cli.BidiState != "" {
+		bidiStatus := runBidi(ctx, cancel, sysStopChan)
+		printStatus(bidiStatus)
+		log.Exit(int(bidiStatus))
+	}
+
 	err := setupStorages(ctx, &syncGroup, &cli)
 	if err != nil {
 		log.Fatalf("Failed to setup storage, error: %s", err)
 	}
+	if cli.StateDB != "" {
+		db, err := state.Open[state.Entry](cli.StateDB)
+		if err != nil {
+			log.Fatalf("Failed to open state database, error: %s", err)
+		}
+		syncState = collection.NewStateConfig(db)
+	}
+	if cli.DryRun {
+		syncPlan = collection.NewPlanConfig()
+		syncPlan.DeleteSource = cli.Move
+	}
 	setupPipeline(&syncGroup, &cli)
+	if err := syncGroup.Validate(); err != nil {
+		log.Errorf("Pipeline configuration error: %s", err)
+		printStatus(syncStatusConfError)
+		log.Exit(int(syncStatusConfError))
+	}
 
-	log.Info("Starting sync")
+	if cli.DryRun {
+		log.Info("Starting dry run")
+	} else {
+		log.Info("Starting sync")
+	}
 	syncGroup.Run()
 
 	if cli.ShowProgress {
@@ -87,6 +124,17 @@ func main() {
 
 	syncStatus := HandleErrors(sysStopChan, cancel, syncGroup)
 
+	if cli.DryRun && syncStatus == syncStatusOk {
+		if err := writePlan(syncPlan); err != nil {
+			log.Errorf("Failed to write dry run plan, error: %s", err)
+			syncStatus = syncStatusFailed
+		}
+	}
+	if syncState != nil {
+		if err := syncState.DB.Close(); err != nil {
+			log.Errorf("Failed to save state database, error: %s", err)
+		}
+	}
 	printFinalStats(&syncGroup, syncStatus)
 	log.Exit(int(syncStatus))
 }
@@ -108,6 +156,11 @@ WaitLoop:
 				break WaitLoop
 			}
 
+			var stateObjErr *pipeline.ObjectError
+			if syncState != nil && errors.As(err, &stateObjErr) {
+				syncState.Discard(stateObjErr.Object)
+			}
+
 			var confErr *pipeline.StepConfigurationError
 			if errors.As(err, &confErr) {
 				log.Errorf("Pipeline configuration error: %s, terminating", confErr)
@@ -123,6 +176,7 @@ WaitLoop:
 				} else {
 					log.Warnf("Skip missing object, err: %s", err)
 				}
+				skipError(err)
 				continue WaitLoop
 			} else if cli.ErrorHandlingMask.Has(storage.HandleErrPermission) && storage.IsErrPermission(err) {
 				var objErr *pipeline.ObjectError
@@ -131,7 +185,19 @@ WaitLoop:
 				} else {
 					log.Warnf("Skip permission denied object, err: %s", err)
 				}
+				skipError(err)
 				continue WaitLoop
+			} else if storage.IsErrChecksumMismatch(err) {
+				if cli.ErrorHandlingMask.Has(storage.HandleErrChecksum) {
+					var objErr *pipeline.ObjectError
+					if errors.As(err, &objErr) {
+						log.Warnf("Skip object with checksum mismatch: %s, error: %s", *objErr.Object.Key, objErr.Err)
+					} else {
+						log.Warnf("Skip object with checksum mismatch, err: %s", err)
+					}
+					skipError(err)
+					continue WaitLoop
+				}
 			} else if cli.ErrorHandlingMask.Has(storage.HandleErrOther) {
 				var objErr *pipeline.ObjectError
 				if errors.As(err, &objErr) {
@@ -139,6 +205,7 @@ WaitLoop:
 				} else {
 					log.Warnf("Sync err: %s, skipping", err)
 				}
+				skipError(err)
 				continue WaitLoop
 			}
 
@@ -151,3 +218,31 @@ WaitLoop:
 	}
 	return syncStatus
 }
+
+// skipError record the object of skipped error in bidiListIndex, if the listing of bidirectional sync is running.
+func skipError(err error) {
+	if bidiListIndex == nil {
+		return
+	}
+	var objErr *pipeline.ObjectError
+	if errors.As(err, &objErr) {
+		bidiListIndex.Skip(*objErr.Object.Key)
+	} else {
+		bidiListIndex.SetIncomplete()
+	}
+}
+
+// runGroup run the group, wait for it and print its stats.
+func runGroup(ctx context.Context, cancel context.CancelFunc, sysStopChan chan os.Signal, group *pipeline.Group) syncStatus {
+	group.Run()
+
+	statsCtx, statsCancel := context.WithCancel(ctx)
+	defer statsCancel()
+	if cli.ShowProgress {
+		go printLiveStats(statsCtx, group)
+	}
+
+	status := HandleErrors(sysStopChan, cancel, *group)
+	printStepsStats(group)
+	return status
+}
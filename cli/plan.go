@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/larrabee/s3sync/pipeline/collection"
+)
+
+// syncPlan collect planned changes in dry run mode.
+var syncPlan *collection.PlanConfig
+
+// planReport is the JSON dry run plan.
+type planReport struct {
+	Source  string                            `json:"source"`
+	Target  string                            `json:"target"`
+	Summary map[string]collection.PlanSummary `json:"summary"`
+	Objects []collection.PlanEntry            `json:"objects"`
+}
+
+// writePlan log the dry run plan and write it to cli.DryRunPlan file.
+// Planned objects are logged only with --sync-log.
+func writePlan(plan *collection.PlanConfig) error {
+	report := planReport{
+		Source:  cli.args.Source,
+		Target:  cli.args.Target,
+		Summary: plan.Summary(),
+		Objects: plan.Entries(),
+	}
+
+	if cli.SyncLog {
+		for _, entry := range report.Objects {
+			log.WithFields(logrus.Fields{
+				"action": entry.Action,
+				"key":    entry.Key,
+				"size":   entry.Size,
+			}).Info("Plan object")
+		}
+	}
+	for _, action := range collection.PlanActions {
+		log.WithFields(logrus.Fields{
+			"action":  action,
+			"objects": report.Summary[action].Objects,
+			"bytes":   report.Summary[action].Bytes,
+		}).Infof("Plan summary")
+	}
+
+	if cli.DryRunPlan == "" {
+		return nil
+	}
+	var w io.Writer = os.Stdout
+	if cli.DryRunPlan != "-" {
+		f, err := os.Create(cli.DryRunPlan)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
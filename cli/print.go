@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
 	"github.com/sirupsen/logrus"
 	"time"
 )
@@ -25,22 +26,11 @@ func printLiveStats(ctx context.Context, syncGroup *pipeline.Group) {
 }
 
 func printFinalStats(syncGroup *pipeline.Group, status syncStatus) {
-	dur := time.Since(syncGroup.StartTime).Seconds()
-	for _, val := range syncGroup.GetStepsInfo() {
-		log.WithFields(logrus.Fields{
-			"stepNum":        val.Num,
-			"stepName":       val.Name,
-			"InputObj":       val.Stats.Input.Load(),
-			"OutputObj":      val.Stats.Output.Load(),
-			"ErrorObj":       val.Stats.Error.Load(),
-			"InputObjSpeed":  float64(val.Stats.Input.Load()) / dur,
-			"OutputObjSpeed": float64(val.Stats.Output.Load()) / dur,
-		}).Info("Pipeline step finished")
-	}
-	log.WithFields(logrus.Fields{
-		"durationSec": time.Since(syncGroup.StartTime).Seconds(),
-	}).Infof("Duration: %s", time.Since(syncGroup.StartTime).String())
+	printStepsStats(syncGroup)
+	printStatus(status)
+}
 
+func printStatus(status syncStatus) {
 	switch status {
 	case syncStatusOk:
 		log.WithFields(logrus.Fields{
@@ -58,9 +48,56 @@ func printFinalStats(syncGroup *pipeline.Group, status syncStatus) {
 		log.WithFields(logrus.Fields{
 			"status": status,
 		}).Errorf("Sync Configuration error")
+	case syncStatusMismatch:
+		log.WithFields(logrus.Fields{
+			"status": status,
+		}).Warnf("Verify found mismatched objects")
 	default:
 		log.WithFields(logrus.Fields{
 			"status": status,
 		}).Warnf("Sync Unknown status")
 	}
 }
+
+func printStepsStats(syncGroup *pipeline.Group) {
+	dur := time.Since(syncGroup.StartTime).Seconds()
+	for _, val := range syncGroup.GetStepsInfo() {
+		fields := logrus.Fields{
+			"stepNum":        val.Num,
+			"stepName":       val.Name,
+			"InputObj":       val.Stats.Input.Load(),
+			"OutputObj":      val.Stats.Output.Load(),
+			"ErrorObj":       val.Stats.Error.Load(),
+			"InputObjSpeed":  float64(val.Stats.Input.Load()) / dur,
+			"OutputObjSpeed": float64(val.Stats.Output.Load()) / dur,
+		}
+		if !isLinearStep(val) {
+			fields["inputs"] = val.Inputs
+			fields["routes"] = val.Routes
+		}
+		log.WithFields(fields).Info("Pipeline step finished")
+
+		if cfg, ok := val.Config.(*collection.FanOutConfig); ok {
+			for _, target := range cfg.Targets {
+				log.WithFields(logrus.Fields{
+					"stepNum":     val.Num,
+					"target":      target.Name,
+					"UploadedObj": target.Stats.Uploaded.Load(),
+					"SkippedObj":  target.Stats.Skipped.Load(),
+					"FailedObj":   target.Stats.Failed.Load(),
+				}).Info("Fan-out target finished")
+			}
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"durationSec": time.Since(syncGroup.StartTime).Seconds(),
+	}).Infof("Duration: %s", time.Since(syncGroup.StartTime).String())
+}
+
+// isLinearStep check that the step has no routes and read only the default output of the previous step.
+func isLinearStep(info pipeline.StepInfo) bool {
+	if len(info.Routes) > 0 || len(info.Inputs) > 1 {
+		return false
+	}
+	return len(info.Inputs) == 0 || (info.Inputs[0].Step == info.Num-1 && info.Inputs[0].Route == "")
+}
@@ -15,90 +15,227 @@ import (
 )
 
 func setupStorages(ctx context.Context, syncGroup *pipeline.Group, cli *argsParsed) error {
-	var sourceStorage, targetStorage storage.Storage
-	var err error
+	sourceStorage, err := newSourceStorage(cli, cli.Source, cli.SourceRegion, cli.SourceEndpoint)
+	if err != nil {
+		return err
+	}
 
-	switch cli.Source.Type {
-	case storage.TypeS3:
-		sourceStorage = s3.NewS3Storage(cli.SourceNoSign, cli.SourceKey, cli.SourceSecret, cli.SourceToken, cli.SourceRegion, cli.SourceEndpoint,
-			cli.Source.Bucket, cli.Source.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval, cli.SkipSSLVerify, cli.ServerGzip,
-		)
-	case storage.TypeS3Stream:
-		sourceStorage = s3stream.NewS3StreamStorage(cli.SourceNoSign, cli.SourceKey, cli.SourceSecret, cli.SourceToken, cli.SourceRegion, cli.SourceEndpoint,
-			cli.Source.Bucket, cli.Source.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval,
-		)
-	case storage.TypeFS:
-		sourceStorage = fs.NewFSStorage(cli.Source.Path, cli.FSFilePerm, cli.FSDirPerm, os.Getpagesize()*256*32, !cli.FSDisableXattr, cli.ErrorHandlingMask, cli.FSAtomicWrite)
-	case storage.TypeSwift:
-		sourceStorage, err = swift.NewStorage(cli.SourceKey, cli.SourceSecret, cli.SourceToken, cli.SourceRegion, cli.SourceEndpoint, cli.Source.Bucket, cli.Source.Path, cli.SwiftRetry, cli.SwiftRetryInterval, cli.SkipSSLVerify)
+	targetStorage, err := newTargetStorage(cli, cli.Target, cli.TargetRegion, cli.TargetEndpoint)
+	if err != nil {
+		return err
+	}
+
+	// Apply context only for source storage. All data modification ops in Target storage should be executed.
+	sourceStorage.WithContext(ctx)
+	//targetStorage.WithContext(ctx)
+
+	if cli.RateLimitBandwidth > 0 {
+		err := sourceStorage.WithRateLimit(cli.RateLimitBandwidth)
+		if err != nil {
+			log.Fatalf("Bandwidth limit error: %s", err)
+		}
+	}
+
+	if len(cli.MergeSources) > 0 {
+		sourceStorage = storage.NewPrefixStorage(sourceStorage, cli.MergeSourcePrefix)
+		syncGroup.AddSource(mergePrimarySource, sourceStorage)
+	}
+	for _, source := range cli.MergeSources {
+		st, err := newSourceStorage(cli, source.Conn, source.Region, source.Endpoint)
 		if err != nil {
+			return fmt.Errorf("merged source %s: %w", source.Name, err)
+		}
+		st.WithContext(ctx)
+		if cli.RateLimitBandwidth > 0 {
+			if err := st.WithRateLimit(cli.RateLimitBandwidth); err != nil {
+				log.Fatalf("Bandwidth limit error: %s", err)
+			}
+		}
+		syncGroup.AddSource(source.Name, storage.NewPrefixStorage(st, source.Prefix))
+	}
+
+	syncGroup.SetSource(sourceStorage)
+	syncGroup.SetTarget(targetStorage)
+
+	if cli.Move || (cli.PipelineDefinition != nil && cli.PipelineDefinition.HasStep("DeleteSourceObjects")) {
+		if err := setupDeleteSources(syncGroup, cli); err != nil {
 			return err
 		}
 	}
 
-	switch cli.Target.Type {
+	if len(cli.FanOutTargets) > 0 {
+		syncGroup.AddTarget(fanOutPrimaryTarget, targetStorage)
+	}
+	for _, target := range cli.FanOutTargets {
+		st, err := newTargetStorage(cli, target.Conn, target.Region, target.Endpoint)
+		if err != nil {
+			return fmt.Errorf("fan-out target %s: %w", target.Name, err)
+		}
+		if target.RateLimitBandwidth > 0 {
+			if err := st.WithRateLimit(target.RateLimitBandwidth); err != nil {
+				log.Fatalf("Bandwidth limit error of fan-out target %s: %s", target.Name, err)
+			}
+		}
+		syncGroup.AddTarget(target.Name, st)
+	}
+	return nil
+}
+
+// newTargetStorage create the target storage with given connection, region and endpoint from cli args.
+func newTargetStorage(cli *argsParsed, conn connect, region, endpoint string) (storage.Storage, error) {
+	targetSSE := s3.SSEConfig{}
+	if cli.S3TargetSSECustomerKey != "" {
+		targetSSE.CustomerKey = &cli.S3TargetSSECustomerKey
+	}
+	if cli.S3SSEKMSKeyId != "" {
+		targetSSE.KMSKeyId = &cli.S3SSEKMSKeyId
+	}
+	if cli.S3SSEBucketKey {
+		targetSSE.BucketKeyEnabled = storage.ToPtr(true)
+	}
+
+	switch conn.Type {
 	case storage.TypeS3:
-		targetStorage = s3.NewS3Storage(cli.TargetNoSign, cli.TargetKey, cli.TargetSecret, cli.TargetToken, cli.TargetRegion, cli.TargetEndpoint,
-			cli.Target.Bucket, cli.Target.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval, cli.SkipSSLVerify, cli.ServerGzip,
+		st := s3.NewS3Storage(cli.TargetNoSign, cli.TargetKey, cli.TargetSecret, cli.TargetToken, region, endpoint,
+			conn.Bucket, conn.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval, cli.SkipSSLVerify, cli.ServerGzip,
 		)
+		st.WithSSE(targetSSE)
+		st.WithChecksums(targetChecksums(cli))
+		return st, nil
 	case storage.TypeS3Stream:
-		targetStorage = s3stream.NewS3StreamStorage(cli.TargetNoSign, cli.TargetKey, cli.TargetSecret, cli.TargetToken, cli.TargetRegion, cli.TargetEndpoint,
-			cli.Target.Bucket, cli.Target.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval,
+		st := s3stream.NewS3StreamStorage(cli.TargetNoSign, cli.TargetKey, cli.TargetSecret, cli.TargetToken, region, endpoint,
+			conn.Bucket, conn.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval,
 		)
+		st.WithSSE(targetSSE)
+		st.WithChecksums(targetChecksums(cli))
+		if len(cli.ChecksumAlgorithms) > 0 {
+			st.WithUploadChecksum(cli.ChecksumAlgorithms[0])
+		}
+		return st, nil
 	case storage.TypeFS:
-		targetStorage = fs.NewFSStorage(cli.Target.Path, cli.FSFilePerm, cli.FSDirPerm, 0, !cli.FSDisableXattr, cli.ErrorHandlingMask, cli.FSAtomicWrite)
+		st := fs.NewFSStorage(conn.Path, cli.FSFilePerm, cli.FSDirPerm, 0, !cli.FSDisableXattr, cli.ErrorHandlingMask, cli.FSAtomicWrite)
+		st.WithKeyEncoding(cli.FSEncodeKeys)
+		st.WithPosixAttrs(cli.FSPreserveAttrs, cli.FSPreserveAtime)
+		st.WithSymlinks(cli.FSSymlinks)
+		st.WithETag(cli.FSETag, cli.FSETagPartSize)
+		st.WithChecksums(targetChecksums(cli))
+		if cli.FSSidecarMeta {
+			st.WithMetaStore(fs.NewSidecarMetaStore(conn.Path, cli.FSFilePerm, cli.FSDirPerm))
+		}
+		return st, nil
 	case storage.TypeSwift:
-		targetStorage, err = swift.NewStorage(cli.TargetKey, cli.TargetSecret, cli.TargetToken, cli.TargetRegion, cli.TargetEndpoint, cli.Target.Bucket, cli.Target.Path, cli.SwiftRetry, cli.SwiftRetryInterval, cli.SkipSSLVerify)
+		targetStorage, err := swift.NewStorage(cli.TargetKey, cli.TargetSecret, cli.TargetToken, region, endpoint, conn.Bucket, conn.Path, cli.SwiftRetry, cli.SwiftRetryInterval, cli.SkipSSLVerify)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return targetStorage, nil
+	}
+	return nil, fmt.Errorf("target storage is nil")
+}
 
-		// func NewStorage(user, key, tenant, domain, authUrl string, bucketName, prefix string, skipSSLVerify bool) (*Storage, error) {
+// targetChecksums check that target storage should load checksums: they are compared by --compare checksum
+// and by verification of uploaded objects before deleting the source objects.
+func targetChecksums(cli *argsParsed) bool {
+	if cli.Compare == collection.CompareChecksum || (cli.MoveVerify && len(cli.ChecksumAlgorithms) > 0) {
+		return true
 	}
+	return cli.PipelineDefinition != nil && collection.HasVerifiedMove(cli.PipelineDefinition)
+}
 
-	if sourceStorage == nil {
-		return fmt.Errorf("source storage is nil")
-	} else if targetStorage == nil {
-		return fmt.Errorf("target storage is nil")
+// setupDeleteSources add source storages without context, so deletes of DeleteSourceObjects step are not interrupted on abort.
+func setupDeleteSources(syncGroup *pipeline.Group, cli *argsParsed) error {
+	st, err := newSourceStorage(cli, cli.Source, cli.SourceRegion, cli.SourceEndpoint)
+	if err != nil {
+		return err
+	}
+	if len(cli.MergeSources) > 0 {
+		syncGroup.AddDeleteSource(mergePrimarySource, storage.NewPrefixStorage(st, cli.MergeSourcePrefix))
+	} else {
+		syncGroup.AddDeleteSource("", st)
 	}
+	for _, source := range cli.MergeSources {
+		st, err := newSourceStorage(cli, source.Conn, source.Region, source.Endpoint)
+		if err != nil {
+			return fmt.Errorf("merged source %s: %w", source.Name, err)
+		}
+		syncGroup.AddDeleteSource(source.Name, storage.NewPrefixStorage(st, source.Prefix))
+	}
+	return nil
+}
 
-	// Apply context only for source storage. All data modification ops in Target storage should be executed.
-	sourceStorage.WithContext(ctx)
-	//targetStorage.WithContext(ctx)
+// newSourceStorage create the source storage with given connection, region and endpoint from cli args.
+func newSourceStorage(cli *argsParsed, conn connect, region, endpoint string) (storage.Storage, error) {
+	sourceSSE := s3.SSEConfig{}
+	if cli.S3SourceSSECustomerKey != "" {
+		sourceSSE.CustomerKey = &cli.S3SourceSSECustomerKey
+	}
 
-	if cli.RateLimitBandwidth > 0 {
-		err := sourceStorage.WithRateLimit(cli.RateLimitBandwidth)
+	switch conn.Type {
+	case storage.TypeS3:
+		st := s3.NewS3Storage(cli.SourceNoSign, cli.SourceKey, cli.SourceSecret, cli.SourceToken, region, endpoint,
+			conn.Bucket, conn.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval, cli.SkipSSLVerify, cli.ServerGzip,
+		)
+		st.WithSSE(sourceSSE)
+		st.WithChecksums(len(cli.ChecksumAlgorithms) > 0 || cli.Compare == collection.CompareChecksum)
+		return st, nil
+	case storage.TypeS3Stream:
+		st := s3stream.NewS3StreamStorage(cli.SourceNoSign, cli.SourceKey, cli.SourceSecret, cli.SourceToken, region, endpoint,
+			conn.Bucket, conn.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval,
+		)
+		st.WithSSE(sourceSSE)
+		st.WithChecksums(len(cli.ChecksumAlgorithms) > 0 || cli.Compare == collection.CompareChecksum)
+		return st, nil
+	case storage.TypeFS:
+		st := fs.NewFSStorage(conn.Path, cli.FSFilePerm, cli.FSDirPerm, os.Getpagesize()*256*32, !cli.FSDisableXattr, cli.ErrorHandlingMask, cli.FSAtomicWrite)
+		st.WithKeyEncoding(cli.FSEncodeKeys)
+		st.WithPosixAttrs(cli.FSPreserveAttrs, cli.FSPreserveAtime)
+		st.WithSymlinks(cli.FSSymlinks)
+		st.WithETag(cli.FSETag, cli.FSETagPartSize)
+		st.WithChecksums(len(cli.ChecksumAlgorithms) > 0 || cli.Compare == collection.CompareChecksum)
+		if cli.FSSidecarMeta {
+			st.WithMetaStore(fs.NewSidecarMetaStore(conn.Path, cli.FSFilePerm, cli.FSDirPerm))
+		}
+		return st, nil
+	case storage.TypeSwift:
+		sourceStorage, err := swift.NewStorage(cli.SourceKey, cli.SourceSecret, cli.SourceToken, region, endpoint, conn.Bucket, conn.Path, cli.SwiftRetry, cli.SwiftRetryInterval, cli.SkipSSLVerify)
 		if err != nil {
-			log.Fatalf("Bandwidth limit error: %s", err)
+			return nil, err
 		}
+		return sourceStorage, nil
 	}
-
-	syncGroup.SetSource(sourceStorage)
-	syncGroup.SetTarget(targetStorage)
-	return nil
+	return nil, fmt.Errorf("source storage is nil")
 }
 
 func setupPipeline(syncGroup *pipeline.Group, cli *argsParsed) {
-	syncGroup.AddPipeStep(pipeline.Step{
-		Name:     "ListSource",
-		Fn:       collection.ListSourceStorage,
-		ChanSize: cli.ListBuffer,
-	})
+	syncGroup.SetObjectTimeout(cli.ObjectTimeout)
+	if cli.PipelineDefinition != nil {
+		if err := cli.PipelineDefinition.AddToGroup(syncGroup); err != nil {
+			log.Fatalf("Failed to setup pipeline from definition, error: %s", err)
+		}
+		return
+	}
 
-	if len(cli.FilterExt) > 0 {
+	if len(cli.MergeSources) > 0 {
+		// SOURCE takes precedence over merged sources, merged sources are in the order of args.
+		sources := []string{mergePrimarySource}
+		for _, source := range cli.MergeSources {
+			sources = append(sources, source.Name)
+		}
+		syncGroup.AddPipeStep(pipeline.NewStep("ListMergedSources", collection.ListMergedSources, collection.NewMergeConfig(sources...)).WithChanSize(cli.ListBuffer))
+	} else {
 		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByExt",
-			Fn:     collection.FilterObjectsByExt,
-			Config: cli.FilterExt,
+			Name:     "ListSource",
+			Fn:       collection.ListSourceStorage,
+			ChanSize: cli.ListBuffer,
 		})
 	}
 
+	if len(cli.FilterExt) > 0 {
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjByExt", collection.FilterObjectsByExt, cli.FilterExt))
+	}
+
 	if len(cli.FilterExtNot) > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByExtNot",
-			Fn:     collection.FilterObjectsByExtNot,
-			Config: cli.FilterExtNot,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjByExtNot", collection.FilterObjectsByExtNot, cli.FilterExtNot))
 	}
 
 	if cli.FilterDirs {
@@ -115,18 +252,20 @@ func setupPipeline(syncGroup *pipeline.Group, cli *argsParsed) {
 		})
 	}
 
-	if cli.FilterExist {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name: "FilterObjectsExist",
-			Fn:   collection.FilterObjectsExist,
-		})
-	}
+	targetIndex := newTargetIndex(cli)
 
-	if cli.FilterExistNot {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name: "FilterObjectsExistNot",
-			Fn:   collection.FilterObjectsExistNot,
-		})
+	compressConfig := collection.CompressConfig{
+		Algorithm:    cli.Compress,
+		RenameKey:    cli.CompressRename,
+		MinSize:      cli.CompressMinSize,
+		ContentTypes: cli.CompressCT,
+	}
+	// With --compress-rename objects are stored in target with other keys, so filters should check the renamed keys.
+	var targetKey collection.TargetKeyFunc
+	if cli.CompressRename && cli.Compress != "" {
+		targetKey = collection.CompressedKey(compressConfig)
+	} else if cli.CompressRename && cli.Decompress {
+		targetKey = collection.DecompressedKey(collection.DecompressConfig(compressConfig))
 	}
 
 	loadObjMetaStep := pipeline.Step{
@@ -134,116 +273,195 @@ func setupPipeline(syncGroup *pipeline.Group, cli *argsParsed) {
 		Fn:         collection.LoadObjectMeta,
 		AddWorkers: cli.Workers,
 	}
-	if (cli.Source.Type == storage.TypeFS) &&
-		((cli.FilterMtimeAfter > 0) || (cli.FilterMtimeBefore > 0) || cli.FilterModified) {
-		syncGroup.AddPipeStep(loadObjMetaStep)
-	} else if (cli.Source.Type != storage.TypeSwift) && (len(cli.FilterCT) > 0) || (len(cli.FilterCTNot) > 0) {
+	// The renamed key depends on object Content-Type, Content-Encoding and size, so meta is loaded before the filters and dry-run plan.
+	metaLoaded := targetKey != nil && (cli.FilterExist || cli.FilterExistNot || cli.FilterModified || cli.DryRun)
+	if metaLoaded {
 		syncGroup.AddPipeStep(loadObjMetaStep)
 	}
 
+	if cli.FilterExist {
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjectsExist", collection.FilterObjectsExist, collection.ExistConfig{
+			TargetIndex: targetIndex,
+			TargetKey:   targetKey,
+		}))
+	}
+
+	if cli.FilterExistNot {
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjectsExistNot", collection.FilterObjectsExistNot, collection.ExistConfig{
+			TargetIndex: targetIndex,
+			TargetKey:   targetKey,
+		}))
+	}
+
+	if !metaLoaded {
+		if (cli.Source.Type == storage.TypeFS) &&
+			((cli.FilterMtimeAfter > 0) || (cli.FilterMtimeBefore > 0) || cli.FilterModified || syncState != nil) {
+			syncGroup.AddPipeStep(loadObjMetaStep)
+		} else if cli.FilterModified && (cli.Compare != collection.CompareETag || cli.CompareNewerOnly) {
+			syncGroup.AddPipeStep(loadObjMetaStep)
+		} else if (cli.Source.Type != storage.TypeSwift) && (len(cli.FilterCT) > 0) || (len(cli.FilterCTNot) > 0) {
+			syncGroup.AddPipeStep(loadObjMetaStep)
+		} else if cli.DryRun && cli.Source.Type == storage.TypeFS {
+			syncGroup.AddPipeStep(loadObjMetaStep)
+		}
+	}
+
 	if cli.FilterMtimeAfter > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjectsByMtimeAfter",
-			Fn:     collection.FilterObjectsByMtimeAfter,
-			Config: cli.FilterMtimeAfter,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjectsByMtimeAfter", collection.FilterObjectsByMtimeAfter, cli.FilterMtimeAfter))
 	}
 
 	if cli.FilterMtimeBefore > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjectsByMtimeBefore",
-			Fn:     collection.FilterObjectsByMtimeBefore,
-			Config: cli.FilterMtimeBefore,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjectsByMtimeBefore", collection.FilterObjectsByMtimeBefore, cli.FilterMtimeBefore))
 	}
 
 	if len(cli.FilterCT) > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByCT",
-			Fn:     collection.FilterObjectsByCT,
-			Config: cli.FilterCT,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjByCT", collection.FilterObjectsByCT, cli.FilterCT))
 	}
 
 	if len(cli.FilterCTNot) > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByCTNot",
-			Fn:     collection.FilterObjectsByCTNot,
-			Config: cli.FilterCTNot,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjByCTNot", collection.FilterObjectsByCTNot, cli.FilterCTNot))
+	}
+
+	if syncState != nil {
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjectsUnchanged", collection.FilterObjectsUnchanged, syncState))
+	}
+
+	// State of objects is saved only after real sync.
+	var saveState *collection.StateConfig
+	if !cli.DryRun {
+		saveState = syncState
 	}
 
 	if cli.FilterModified {
+		syncGroup.AddPipeStep(pipeline.NewStep("FilterObjectsModified", collection.FilterObjectsModified, collection.CompareConfig{
+			Comparator:     collection.Comparators[cli.Compare],
+			MtimeTolerance: cli.CompareMtimeTol,
+			NewerOnly:      cli.CompareNewerOnly,
+			TargetIndex:    targetIndex,
+			TargetKey:      targetKey,
+			State:          saveState,
+		}).WithWorkers(cli.Workers))
+	}
+
+	if !cli.DryRun {
 		syncGroup.AddPipeStep(pipeline.Step{
-			Name: "FilterObjectsModified",
-			Fn:   collection.FilterObjectsModified,
+			Name:       "LoadObjData",
+			Fn:         collection.LoadObjectData,
+			AddWorkers: cli.Workers,
 		})
 	}
 
-	syncGroup.AddPipeStep(pipeline.Step{
-		Name:       "LoadObjData",
-		Fn:         collection.LoadObjectData,
-		AddWorkers: cli.Workers,
-	})
+	if cli.CSEMode == "decrypt" && !cli.DryRun {
+		syncGroup.AddPipeStep(pipeline.NewStep("DecryptObj", collection.DecryptObjectData, collection.DecryptConfig{
+			Key:        cli.CSEKey,
+			Identities: cli.CSEIdentities,
+		}).WithWorkers(cli.Workers))
+	}
+
+	if cli.Decompress && !cli.DryRun {
+		syncGroup.AddPipeStep(pipeline.NewStep("DecompressObj", collection.DecompressObjectData, collection.DecompressConfig(compressConfig)).WithWorkers(cli.Workers))
+	}
 
-	if cli.S3Acl == "copy" && cli.Source.Type == storage.TypeS3 {
+	if cli.S3Acl == "copy" && cli.Source.Type == storage.TypeS3 && !cli.DryRun {
 		syncGroup.AddPipeStep(pipeline.Step{
 			Name:       "LoadObjACL",
 			Fn:         collection.LoadObjectACL,
 			AddWorkers: cli.Workers,
 		})
 	} else if cli.S3Acl != "" {
+		syncGroup.AddPipeStep(pipeline.NewStep("ACLUpdater", collection.ACLUpdater, cli.S3Acl))
+	}
+
+	if cli.S3CopyTags && (cli.Source.Type == storage.TypeS3 || cli.Source.Type == storage.TypeS3Stream) && !cli.DryRun {
 		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "ACLUpdater",
-			Fn:     collection.ACLUpdater,
-			Config: cli.S3Acl,
+			Name:       "LoadObjTags",
+			Fn:         collection.LoadObjectTags,
+			AddWorkers: cli.Workers,
 		})
 	}
 
-	if cli.S3StorageClass != "" {
+	if len(cli.S3Tags) > 0 {
+		syncGroup.AddPipeStep(pipeline.NewStep("TagsUpdater", collection.TagsUpdater, cli.S3Tags))
+	}
+
+	if cli.S3CopyObjectLock && (cli.Source.Type == storage.TypeS3 || cli.Source.Type == storage.TypeS3Stream) && !cli.DryRun {
 		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "StorageClassUpdater",
-			Fn:     collection.StorageClassUpdater,
-			Config: cli.S3StorageClass,
+			Name:       "LoadObjLock",
+			Fn:         collection.LoadObjectLock,
+			AddWorkers: cli.Workers,
 		})
 	}
 
+	if cli.S3ObjectLockMode != "" || cli.S3ObjectLockLegalHold != "" {
+		syncGroup.AddPipeStep(pipeline.NewStep("ObjectLockUpdater", collection.ObjectLockUpdater, collection.ObjectLockConfig{
+			Mode:            cli.S3ObjectLockMode,
+			RetainPeriod:    cli.S3ObjectLockPeriod,
+			LegalHoldStatus: cli.S3ObjectLockLegalHold,
+		}))
+	}
+
+	if cli.S3StorageClass != "" {
+		syncGroup.AddPipeStep(pipeline.NewStep("StorageClassUpdater", collection.StorageClassUpdater, cli.S3StorageClass))
+	}
+
 	if cli.S3CacheControl != "" {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "CacheControlUpdater",
-			Fn:     collection.CacheControlUpdater,
-			Config: cli.S3CacheControl,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("CacheControlUpdater", collection.CacheControlUpdater, cli.S3CacheControl))
 	}
 
 	if cli.S3ServerSideEncryption != "" {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "ServerSideEncryption",
-			Fn:     collection.ServerSideEncryptionUpdater,
-			Config: cli.S3ServerSideEncryption,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("ServerSideEncryption", collection.ServerSideEncryptionUpdater, cli.S3ServerSideEncryption))
 	}
 
-	syncGroup.AddPipeStep(pipeline.Step{
-		Name:       "UploadObj",
-		Fn:         collection.UploadObjectData,
-		AddWorkers: cli.Workers,
-	})
+	if len(cli.RewriteKeyRules) > 0 {
+		syncGroup.AddPipeStep(pipeline.NewStep("RewriteObjKey", collection.RewriteObjectKey, collection.NewKeyRewriteConfig(cli.RewriteKeyRules, !cli.RewriteKeySkipCollisions)))
+	}
+
+	if cli.Compress != "" && !cli.DryRun {
+		syncGroup.AddPipeStep(pipeline.NewStep("CompressObj", collection.CompressObjectData, compressConfig).WithWorkers(cli.Workers))
+	}
+
+	if cli.CSEMode == "encrypt" && !cli.DryRun {
+		syncGroup.AddPipeStep(pipeline.NewStep("EncryptObj", collection.EncryptObjectData, collection.EncryptConfig{
+			Key:        cli.CSEKey,
+			Recipients: cli.CSERecipients,
+		}).WithWorkers(cli.Workers))
+	}
+
+	if len(cli.ChecksumAlgorithms) > 0 && !cli.DryRun {
+		syncGroup.AddPipeStep(pipeline.NewStep("ChecksumObj", collection.ChecksumObjectData, collection.ChecksumConfig{Algorithms: cli.ChecksumAlgorithms}).WithWorkers(cli.Workers))
+	}
 
-	if cli.SyncLog {
+	if cli.DryRun {
+		syncPlan.TargetIndex = targetIndex
+		syncPlan.TargetKey = targetKey
+		syncGroup.AddPipeStep(pipeline.NewStep("PlanObj", collection.PlanObjects, syncPlan).WithWorkers(cli.Workers))
+	} else if len(cli.FanOutTargets) > 0 {
+		syncGroup.AddPipeStep(pipeline.NewStep("UploadObjFanOut", collection.UploadObjectDataFanOut, newFanOutConfig(cli)).WithWorkers(cli.Workers))
+	} else {
 		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "Logger",
-			Fn:     collection.Logger,
-			Config: log,
+			Name:       "UploadObj",
+			Fn:         collection.UploadObjectData,
+			AddWorkers: cli.Workers,
 		})
 	}
 
+	if syncState != nil && !cli.DryRun {
+		syncGroup.AddPipeStep(pipeline.NewStep("SaveObjState", collection.SaveObjectsState, syncState))
+	}
+
+	if cli.Move && !cli.DryRun {
+		// Deletes use the delete source storages without context, so they are not interrupted on abort, see setupDeleteSources.
+		syncGroup.AddPipeStep(pipeline.NewStep("DeleteSrcObj", collection.DeleteSourceObjects, collection.MoveConfig{
+			Verify: cli.MoveVerify,
+		}).WithWorkers(cli.Workers))
+	}
+
+	if cli.SyncLog && !cli.DryRun {
+		syncGroup.AddPipeStep(pipeline.NewStep("Logger", collection.Logger, log))
+	}
+
 	if cli.RateLimitObjPerSec > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "RateLimit",
-			Fn:     collection.PipelineRateLimit,
-			Config: cli.RateLimitObjPerSec,
-		})
+		syncGroup.AddPipeStep(pipeline.NewStep("RateLimit", collection.PipelineRateLimit, collection.RateLimitConfig(cli.RateLimitObjPerSec)))
 	}
 
 	syncGroup.AddPipeStep(pipeline.Step{
@@ -251,3 +469,46 @@ func setupPipeline(syncGroup *pipeline.Group, cli *argsParsed) {
 		Fn:   collection.Terminator,
 	})
 }
+
+// newTargetIndex return the target index shared by filters or nil if per-object requests should be used.
+// Listed objects are used as loaded meta only if S3 listing contains all the meta required by comparator.
+func newTargetIndex(cli *argsParsed) *collection.TargetIndex {
+	listedMeta := (cli.Target.Type == storage.TypeS3 || cli.Target.Type == storage.TypeS3Stream) &&
+		(cli.Compare == collection.CompareETag || cli.Compare == collection.CompareSize) && !cli.CompareNewerOnly
+
+	var idx *collection.TargetIndex
+	switch cli.TargetIndex {
+	case targetIndexList:
+		idx = collection.NewTargetIndex(0, listedMeta)
+	case targetIndexAuto:
+		idx = collection.NewTargetIndex(cli.TargetIndexThreshold, listedMeta)
+	default:
+		return nil
+	}
+	idx.MaxObjects = cli.TargetIndexMaxObjects
+	idx.SpillDir = cli.TargetIndexSpillDir
+	idx.ListWorkers = int(cli.Workers)
+	return idx
+}
+
+// newFanOutConfig return the configuration of fan-out upload to selected targets, all targets are used by default.
+func newFanOutConfig(cli *argsParsed) *collection.FanOutConfig {
+	selected := make(map[string]bool)
+	for _, name := range cli.FanOutSelect {
+		selected[name] = true
+	}
+	targets := append([]fanOutTarget{{Name: fanOutPrimaryTarget, ErrorHandlingMask: cli.ErrorHandlingMask}}, cli.FanOutTargets...)
+
+	cfg := &collection.FanOutConfig{}
+	for _, target := range targets {
+		if len(selected) > 0 && !selected[target.Name] {
+			continue
+		}
+		t, err := collection.NewFanOutTarget(target.Name, target.ErrorHandlingMask, target.RateLimitObjPerSec)
+		if err != nil {
+			log.Fatalf("Rate limit error of fan-out target %s: %s", target.Name, err)
+		}
+		cfg.Targets = append(cfg.Targets, t)
+	}
+	return cfg
+}
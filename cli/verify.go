@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/storage"
+)
+
+const verifyCommand = "verify"
+
+const (
+	verifyReportJSON = "json"
+	verifyReportCSV  = "csv"
+)
+
+// verifyReport is the JSON report of verify command.
+type verifyReport struct {
+	Source  string                    `json:"source"`
+	Target  string                    `json:"target"`
+	Compare string                    `json:"compare"`
+	Checked uint64                    `json:"checked"`
+	Missing int                       `json:"missing"`
+	Extra   int                       `json:"extra"`
+	Differ  int                       `json:"differ"`
+	Objects []collection.VerifyResult `json:"objects"`
+}
+
+// runVerify compare source and target storages, write the report and return the verify status.
+// Source objects are checked against the target in the first pass, then target is listed to find extra objects.
+func runVerify(ctx context.Context, cancel context.CancelFunc, sysStopChan chan os.Signal) syncStatus {
+	verifyCfg := collection.NewVerifyConfig(collection.CompareConfig{
+		Comparator:     collection.Comparators[cli.Compare],
+		MtimeTolerance: cli.CompareMtimeTol,
+	})
+
+	verifyGroup := pipeline.NewGroup()
+	err := setupStorages(ctx, &verifyGroup, &cli)
+	if err != nil {
+		log.Fatalf("Failed to setup storage, error: %s", err)
+	}
+	// Verify does not modify storages, so the target can be aborted too.
+	verifyGroup.Target.WithContext(ctx)
+	setupVerifyPipeline(&verifyGroup, verifyCfg, needVerifyMeta(cli.Source.Type), collection.VerifyObjects)
+
+	log.Info("Starting verify")
+	status := runGroup(ctx, cancel, sysStopChan, &verifyGroup)
+	if status != syncStatusOk {
+		return status
+	}
+
+	extraGroup := pipeline.NewGroup()
+	extraGroup.SetSource(verifyGroup.Target)
+	extraGroup.SetTarget(verifyGroup.Source)
+	setupVerifyPipeline(&extraGroup, verifyCfg, false, collection.VerifyExtraObjects)
+
+	log.Info("Starting search of extra objects in target")
+	status = runGroup(ctx, cancel, sysStopChan, &extraGroup)
+	if status != syncStatusOk {
+		return status
+	}
+
+	if err := writeVerifyReport(verifyCfg); err != nil {
+		log.Errorf("Failed to write verify report, error: %s", err)
+		return syncStatusFailed
+	}
+	if len(verifyCfg.Results()) > 0 {
+		return syncStatusMismatch
+	}
+	return syncStatusOk
+}
+
+// needVerifyMeta check that source objects meta should be loaded before comparison.
+// S3 listing already contains ETag and size.
+func needVerifyMeta(sourceType storage.Type) bool {
+	if sourceType != storage.TypeS3 && sourceType != storage.TypeS3Stream {
+		return true
+	}
+	switch cli.Compare {
+	case collection.CompareETag, collection.CompareSize, collection.CompareContent:
+		return false
+	}
+	return true
+}
+
+func setupVerifyPipeline(group *pipeline.Group, verifyCfg *collection.VerifyConfig, loadMeta bool, verifyFn pipeline.TypedStepFn[*collection.VerifyConfig]) {
+	group.SetObjectTimeout(cli.ObjectTimeout)
+	group.AddPipeStep(pipeline.Step{
+		Name:     "ListSource",
+		Fn:       collection.ListSourceStorage,
+		ChanSize: cli.ListBuffer,
+	})
+
+	if loadMeta {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "LoadObjMeta",
+			Fn:         collection.LoadObjectMeta,
+			AddWorkers: cli.Workers,
+		})
+	}
+
+	group.AddPipeStep(pipeline.NewStep("VerifyObj", verifyFn, verifyCfg).WithWorkers(cli.Workers))
+
+	group.AddPipeStep(pipeline.Step{
+		Name: "Terminator",
+		Fn:   collection.Terminator,
+	})
+}
+
+// writeVerifyReport write the report to cli.VerifyReport in cli.VerifyReportFormat.
+func writeVerifyReport(verifyCfg *collection.VerifyConfig) error {
+	var w io.Writer = os.Stdout
+	if cli.VerifyReport != "-" {
+		f, err := os.Create(cli.VerifyReport)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	report := verifyReport{
+		Source:  cli.args.Source,
+		Target:  cli.args.Target,
+		Compare: cli.Compare,
+		Checked: verifyCfg.Checked(),
+		Missing: verifyCfg.Count(collection.VerifyMissing),
+		Extra:   verifyCfg.Count(collection.VerifyExtra),
+		Differ:  verifyCfg.Count(collection.VerifyDiffer),
+		Objects: verifyCfg.Results(),
+	}
+	log.WithFields(logrus.Fields{
+		"checked": report.Checked,
+		"missing": report.Missing,
+		"extra":   report.Extra,
+		"differ":  report.Differ,
+	}).Info("Verify finished")
+
+	switch cli.VerifyReportFormat {
+	case verifyReportCSV:
+		return writeVerifyCSV(w, report.Objects)
+	default:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+}
+
+func writeVerifyCSV(w io.Writer, results []collection.VerifyResult) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"key", "status", "source_size", "target_size", "source_etag", "target_etag"})
+	for _, res := range results {
+		_ = cw.Write([]string{
+			res.Key,
+			res.Status,
+			formatOptInt(res.SourceSize),
+			formatOptInt(res.TargetSize),
+			storage.ToValue(res.SourceETag),
+			storage.ToValue(res.TargetETag),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatOptInt(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v, 10)
+}
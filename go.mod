@@ -3,20 +3,24 @@ module github.com/larrabee/s3sync
 go 1.18
 
 require (
+	filippo.io/age v1.1.1
 	github.com/alexflint/go-arg v1.4.2
 	github.com/aws/aws-sdk-go v1.44.166
 	github.com/gophercloud/gophercloud v1.1.1
 	github.com/gosuri/uilive v0.0.3
 	github.com/karrick/godirwalk v1.16.1
+	github.com/klauspost/compress v1.15.13
 	github.com/larrabee/ratelimit v1.0.4
 	github.com/mattn/go-isatty v0.0.12
-	github.com/ncw/swift/v2 v2.0.1
 	github.com/pkg/xattr v0.4.2
 	github.com/sirupsen/logrus v1.8.1
+	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
 	github.com/alexflint/go-scalar v1.1.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	golang.org/x/sys v0.1.0 // indirect
+	golang.org/x/crypto v0.4.0 // indirect
+	golang.org/x/sys v0.3.0 // indirect
 )
@@ -0,0 +1,294 @@
+package collection
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/state"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Conflict resolution policies of bidirectional sync.
+const (
+	// BidiConflictNewer copy the object with newer mtime over the other one.
+	BidiConflictNewer = "newer"
+	// BidiConflictKeepBoth keep the newer object under its key and save the older one with BidiConfig.ConflictSuffix on both sides.
+	BidiConflictKeepBoth = "keep-both"
+	// BidiConflictFail abort the sync without any changes.
+	BidiConflictFail = "fail"
+)
+
+// BidiSide is a side of bidirectional sync.
+type BidiSide int
+
+// Sides of bidirectional sync.
+const (
+	BidiSource BidiSide = iota
+	BidiTarget
+)
+
+func (s BidiSide) other() BidiSide {
+	if s == BidiSource {
+		return BidiTarget
+	}
+	return BidiSource
+}
+
+func (s BidiSide) String() string {
+	if s == BidiSource {
+		return "source"
+	}
+	return "target"
+}
+
+// BidiState is the state of the key on both sides at the last bidirectional sync.
+type BidiState struct {
+	Source state.Entry `json:"s"`
+	Target state.Entry `json:"t"`
+}
+
+// BidiConfig is a configuration of bidirectional sync reconciliation.
+type BidiConfig struct {
+	// Compare is used to check that objects changed on both sides are equal and it is not a conflict.
+	Compare CompareConfig
+	// Conflict is the conflict resolution policy: BidiConflictNewer, BidiConflictKeepBoth or BidiConflictFail.
+	Conflict string
+	// ConflictSuffix is added to the key of the older object with BidiConflictKeepBoth policy.
+	ConflictSuffix string
+	// MaxDelete is the max number of planned deletions, reconciliation fails if more objects would be deleted.
+	// Zero means no limit.
+	MaxDelete int
+}
+
+// BidiAction is a change planned by bidirectional sync.
+// Copy action read object Key from From side and write it as ToKey to To side.
+// Delete action remove object ToKey from To side.
+type BidiAction struct {
+	Delete bool
+	From   BidiSide
+	Key    string
+	To     BidiSide
+	ToKey  string
+}
+
+// BidiConflict is a key changed on both sides.
+type BidiConflict struct {
+	Key    string
+	Reason string
+}
+
+// BidiPlan is the result of bidirectional sync reconciliation.
+// Actions should be applied in phases: Preserve, Copy and Delete.
+// The state of a key is updated only when all actions writing to the key are succeeded.
+type BidiPlan struct {
+	Preserve  []BidiAction
+	Copy      []BidiAction
+	Delete    []BidiAction
+	Conflicts []BidiConflict
+	// InSync contain keys that are equal on both sides, but have outdated state.
+	InSync []string
+	// Forget contain keys that are deleted on both sides.
+	Forget []string
+
+	mu      sync.Mutex
+	pending map[string]int
+	failed  map[string]bool
+}
+
+func (plan *BidiPlan) add(phase *[]BidiAction, action BidiAction) {
+	*phase = append(*phase, action)
+	plan.pending[action.ToKey]++
+}
+
+// Done mark the action as succeeded.
+func (plan *BidiPlan) Done(action BidiAction) {
+	plan.mu.Lock()
+	plan.pending[action.ToKey]--
+	plan.mu.Unlock()
+}
+
+// Failed mark the action as failed, the state of the key will not be updated.
+func (plan *BidiPlan) Failed(action BidiAction) {
+	plan.mu.Lock()
+	plan.failed[action.ToKey] = true
+	plan.mu.Unlock()
+}
+
+// Completed return keys whose actions are all succeeded.
+func (plan *BidiPlan) Completed() []string {
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	keys := make([]string, 0, len(plan.pending))
+	for key, pending := range plan.pending {
+		if pending == 0 && !plan.failed[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// BidiReconcile compare listed objects of both sides with the state of the last sync and return the plan of changes.
+// Objects in indexes should have loaded metadata. Source and Target of the group should be the source and target sides,
+// they are used by BidiConfig.Compare comparator.
+//
+// A key is changed on a side if it is created or its state.Entry differs from the stored one.
+// Changes and deletions are propagated to the other side.
+// A key changed on both sides, or changed on one side and deleted on the other, is a conflict
+// and resolved by BidiConfig.Conflict policy. Modification wins over deletion for all policies except BidiConflictFail.
+//
+// Keys skipped on any side (see ObjectIndex.Skip) are not changed and keep its state.
+// Reconciliation fails without plan if an index is incomplete, if a side is listed empty while the state is not empty
+// (like unmounted FS dir), or if planned deletions exceed BidiConfig.MaxDelete.
+func BidiReconcile(group *pipeline.Group, src, dst *ObjectIndex, db *state.DB[BidiState], cfg BidiConfig) (*BidiPlan, error) {
+	if cfg.Compare.Comparator == nil {
+		cfg.Compare.Comparator = CompareObjectsByETag
+	}
+	plan := &BidiPlan{
+		pending: make(map[string]int),
+		failed:  make(map[string]bool),
+	}
+
+	stateKeys := db.Keys()
+	for side, index := range []*ObjectIndex{BidiSource: src, BidiTarget: dst} {
+		if index.Incomplete() {
+			return nil, fmt.Errorf("listing of %s is incomplete because of skipped errors", BidiSide(side))
+		}
+		if index.Len() == 0 && len(stateKeys) > 0 {
+			return nil, fmt.Errorf("%s is listed empty, but the state contains %d keys. Remove the state file to sync into empty storage", BidiSide(side), len(stateKeys))
+		}
+	}
+
+	keys := make(map[string]struct{})
+	for _, key := range src.Keys() {
+		keys[key] = struct{}{}
+	}
+	for _, key := range dst.Keys() {
+		keys[key] = struct{}{}
+	}
+	for _, key := range stateKeys {
+		keys[key] = struct{}{}
+	}
+
+	for key := range keys {
+		if src.IsSkipped(key) || dst.IsSkipped(key) {
+			storage.Log.Debugf("Object %s is skipped on listing, not changed", key)
+			continue
+		}
+		srcObj, srcOk := src.Get(key)
+		dstObj, dstOk := dst.Get(key)
+		prev, prevOk := db.Get(key)
+		srcChanged := srcOk && (!prevOk || state.NewEntry(srcObj) != prev.Source)
+		dstChanged := dstOk && (!prevOk || state.NewEntry(dstObj) != prev.Target)
+
+		switch {
+		case !srcOk && !dstOk:
+			plan.Forget = append(plan.Forget, key)
+		case srcOk && dstOk:
+			switch {
+			case srcChanged && dstChanged:
+				modified, err := cfg.Compare.Comparator(group, srcObj, dstObj, cfg.Compare)
+				if err != nil {
+					return nil, fmt.Errorf("failed to compare object %s: %w", key, err)
+				}
+				if !modified {
+					plan.InSync = append(plan.InSync, key)
+				} else if err := plan.conflict(key, srcObj, dstObj, cfg); err != nil {
+					return nil, err
+				}
+			case srcChanged:
+				plan.add(&plan.Copy, BidiAction{From: BidiSource, Key: key, To: BidiTarget, ToKey: key})
+			case dstChanged:
+				plan.add(&plan.Copy, BidiAction{From: BidiTarget, Key: key, To: BidiSource, ToKey: key})
+			}
+		case srcOk:
+			plan.propagate(key, BidiSource, prevOk, srcChanged, cfg)
+		case dstOk:
+			plan.propagate(key, BidiTarget, prevOk, dstChanged, cfg)
+		}
+	}
+	if cfg.MaxDelete > 0 && len(plan.Delete) > cfg.MaxDelete {
+		return nil, fmt.Errorf("planned %d deletions exceed the limit of %d", len(plan.Delete), cfg.MaxDelete)
+	}
+	return plan, nil
+}
+
+// propagate plan changes of the key that exists only on given side.
+func (plan *BidiPlan) propagate(key string, side BidiSide, prevOk, changed bool, cfg BidiConfig) {
+	switch {
+	case !prevOk:
+		plan.add(&plan.Copy, BidiAction{From: side, Key: key, To: side.other(), ToKey: key})
+	case !changed:
+		plan.add(&plan.Delete, BidiAction{Delete: true, To: side, ToKey: key})
+	case cfg.Conflict == BidiConflictFail:
+		plan.Conflicts = append(plan.Conflicts, BidiConflict{Key: key, Reason: fmt.Sprintf("modified on %s, deleted on %s", side, side.other())})
+	default:
+		plan.add(&plan.Copy, BidiAction{From: side, Key: key, To: side.other(), ToKey: key})
+	}
+}
+
+// conflict plan resolution of the key modified on both sides.
+func (plan *BidiPlan) conflict(key string, srcObj, dstObj *storage.Object, cfg BidiConfig) error {
+	winner := BidiSource
+	if isTargetNewer(srcObj, dstObj, 0) {
+		winner = BidiTarget
+	}
+	loser := winner.other()
+
+	switch cfg.Conflict {
+	case BidiConflictFail:
+		plan.Conflicts = append(plan.Conflicts, BidiConflict{Key: key, Reason: "modified on both sides"})
+	case BidiConflictNewer:
+		plan.add(&plan.Copy, BidiAction{From: winner, Key: key, To: loser, ToKey: key})
+	case BidiConflictKeepBoth:
+		suffixKey := key + cfg.ConflictSuffix
+		plan.add(&plan.Preserve, BidiAction{From: loser, Key: key, To: loser, ToKey: suffixKey})
+		plan.add(&plan.Preserve, BidiAction{From: loser, Key: key, To: winner, ToKey: suffixKey})
+		plan.add(&plan.Copy, BidiAction{From: winner, Key: key, To: loser, ToKey: key})
+	default:
+		return fmt.Errorf("unknown conflict policy: %s", cfg.Conflict)
+	}
+	return nil
+}
+
+// BidiApplyConfig is a configuration of ApplyBidiActions step.
+type BidiApplyConfig struct {
+	Plan *BidiPlan
+	// Actions by object key. Key is BidiAction.Key for copy and BidiAction.ToKey for delete actions.
+	Actions map[string]BidiAction
+}
+
+// ApplyBidiActions read objects from input and apply bidirectional sync actions to them.
+// Copy actions read object content from Source storage and put it to Target storage with BidiAction.ToKey.
+// Delete actions remove object from Target storage.
+// The group Source and Target should be the From and To sides of actions.
+// Succeeded actions are marked in BidiPlan and objects are sent to next pipeline steps.
+//
+// This step take configuration of BidiApplyConfig type, see pipeline.NewStep.
+var ApplyBidiActions pipeline.TypedStepFn[BidiApplyConfig] = func(group *pipeline.Group, stepNum int, cfg BidiApplyConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		action, found := cfg.Actions[*obj.Key]
+		if !found {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: fmt.Errorf("no bidirectional sync action for the object")}
+			continue
+		}
+
+		var err error
+		if action.Delete {
+			err = group.Target.DeleteObject(obj)
+		} else if err = group.Source.GetObjectContent(obj); err == nil {
+			if action.ToKey != action.Key {
+				obj.OriginalKey = obj.Key
+				obj.Key = storage.ToPtr(action.ToKey)
+			}
+			err = group.Target.PutObject(obj)
+		}
+		if err != nil {
+			cfg.Plan.Failed(action)
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+		cfg.Plan.Done(action)
+		output <- obj
+	}
+}
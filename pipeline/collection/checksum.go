@@ -0,0 +1,121 @@
+package collection
+
+import (
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// ChecksumConfig is a configuration of ChecksumObjectData step.
+type ChecksumConfig struct {
+	// Algorithms is a list of checksum algorithms to compute, see storage.ChecksumAlgorithms.
+	Algorithms []string
+}
+
+// Validate check that all checksum algorithms are supported.
+func (cfg ChecksumConfig) Validate() error {
+	for _, alg := range cfg.Algorithms {
+		if _, err := storage.NewChecksumHash(alg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChecksumObjectData read objects from input, compute its content checksums and send it to next pipeline steps.
+// Checksums are saved to the object checksum fields, so the target storage can send them to the server
+// for validation (S3) or record them in metadata (FS).
+// If the object already has a full object checksum provided by the source storage, it is verified
+// and storage.ChecksumMismatchError is returned on mismatch.
+// For ContentStream objects checksums are computed on the fly while the target storage reads the stream,
+// mismatch fails the upload.
+//
+// This step should be placed right before the upload step, because any content transformation resets checksums.
+//
+// This filter take configuration of ChecksumConfig type, see pipeline.NewStep.
+var ChecksumObjectData pipeline.TypedStepFn[ChecksumConfig] = func(group *pipeline.Group, stepNum int, cfg ChecksumConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		if err := checksumObject(obj, cfg); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+		} else {
+			output <- obj
+		}
+	}
+}
+
+func checksumObject(obj *storage.Object, cfg ChecksumConfig) error {
+	cr := &checksumReader{obj: obj}
+	for _, alg := range storage.ChecksumAlgorithms {
+		expected := storage.FullObjectChecksum(storage.GetChecksum(obj, alg))
+		wanted := false
+		for _, a := range cfg.Algorithms {
+			if a == alg {
+				wanted = true
+				break
+			}
+		}
+		if expected == nil && !wanted {
+			continue
+		}
+		h, _ := storage.NewChecksumHash(alg)
+		cr.algorithms = append(cr.algorithms, alg)
+		cr.hashes = append(cr.hashes, h)
+		cr.expected = append(cr.expected, expected)
+	}
+
+	if obj.Content != nil {
+		for _, h := range cr.hashes {
+			h.Write(*obj.Content)
+		}
+		return cr.finish()
+	} else if obj.ContentStream != nil {
+		// Checksums are unknown until the stream is read.
+		for _, alg := range cr.algorithms {
+			storage.SetChecksum(obj, alg, nil)
+		}
+		cr.ReadCloser = obj.ContentStream
+		obj.ContentStream = cr
+		return nil
+	}
+	return errors.New("object has no content")
+}
+
+// checksumReader is a io.ReadCloser that computes checksums of read content.
+// On EOF it verifies checksums and save them to the object.
+type checksumReader struct {
+	io.ReadCloser
+	obj        *storage.Object
+	algorithms []string
+	hashes     []hash.Hash
+	expected   []*string
+	done       bool
+}
+
+// Read content from underlying reader and update checksums.
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	for _, h := range r.hashes {
+		h.Write(p[:n])
+	}
+	if err == io.EOF && !r.done {
+		r.done = true
+		if cErr := r.finish(); cErr != nil {
+			return n, cErr
+		}
+	}
+	return n, err
+}
+
+func (r *checksumReader) finish() error {
+	for i, alg := range r.algorithms {
+		sum := storage.EncodeChecksum(r.hashes[i].Sum(nil))
+		if r.expected[i] != nil && *r.expected[i] != sum {
+			return &storage.ChecksumMismatchError{Algorithm: alg, Expected: *r.expected[i], Actual: sum}
+		}
+		storage.SetChecksum(r.obj, alg, &sum)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package collection
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"time"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Names of comparators in Comparators.
+const (
+	CompareETag      = "etag"
+	CompareSize      = "size"
+	CompareMtime     = "mtime"
+	CompareSizeMtime = "size+mtime"
+	CompareChecksum  = "checksum"
+	CompareContent   = "content"
+)
+
+// ObjectComparator decide whether source object differs from the target one.
+// Both objects have loaded metadata. It returns true if the source object should be synced.
+type ObjectComparator func(group *pipeline.Group, src, dst *storage.Object, cfg CompareConfig) (bool, error)
+
+// Comparators contain available object comparators by name.
+var Comparators = map[string]ObjectComparator{
+	CompareETag:      CompareObjectsByETag,
+	CompareSize:      CompareObjectsBySize,
+	CompareMtime:     CompareObjectsByMtime,
+	CompareSizeMtime: CompareObjectsBySizeMtime,
+	CompareChecksum:  CompareObjectsByChecksum,
+	CompareContent:   CompareObjectsByContent,
+}
+
+// CompareConfig is a configuration of FilterObjectsModified step.
+type CompareConfig struct {
+	// Comparator is used to detect modified objects. If nil, CompareObjectsByETag is used.
+	Comparator ObjectComparator
+	// MtimeTolerance is the max difference of mtimes that are considered equal.
+	// Use it to handle different mtime precision of storages.
+	MtimeTolerance time.Duration
+	// NewerOnly skip modified objects if the target object is newer than the source one.
+	NewerOnly bool
+	// TargetIndex is used to look up target objects in the target listing. If nil, target GetObjectMeta is used.
+	TargetIndex *TargetIndex
+	// TargetKey return the key of the object in target storage. If nil, the source key is used.
+	TargetKey TargetKeyFunc
+	// State is the state of FilterObjectsUnchanged step. If set, the state of not modified objects is saved,
+	// so they are skipped by FilterObjectsUnchanged on next runs, and the state of skipped newer objects is discarded.
+	State *StateConfig
+}
+
+// TargetKeyFunc return the key of the object in target storage.
+// Use it in filters when the key is changed by the next steps, like compression with CompressConfig.RenameKey.
+type TargetKeyFunc func(obj *storage.Object) string
+
+// targetKey return the key of the object in target storage or the object key if fn is nil.
+func targetKey(fn TargetKeyFunc, obj *storage.Object) *string {
+	if fn == nil {
+		return obj.Key
+	}
+	return storage.ToPtr(fn(obj))
+}
+
+// CompareObjectsByETag detect modified objects by ETag.
+// Objects without ETag are always considered modified.
+var CompareObjectsByETag ObjectComparator = func(group *pipeline.Group, src, dst *storage.Object, cfg CompareConfig) (bool, error) {
+	return src.ETag == nil || dst.ETag == nil || *src.ETag != *dst.ETag, nil
+}
+
+// CompareObjectsBySize detect modified objects by content size.
+// Objects with unknown size are always considered modified.
+var CompareObjectsBySize ObjectComparator = func(group *pipeline.Group, src, dst *storage.Object, cfg CompareConfig) (bool, error) {
+	return src.ContentLength == nil || dst.ContentLength == nil || *src.ContentLength != *dst.ContentLength, nil
+}
+
+// CompareObjectsByMtime detect modified objects by modification time with CompareConfig.MtimeTolerance.
+// Original mtime from object metadata (storage.MetaMtime) is preferred over object Mtime,
+// because S3 set Mtime to upload time.
+// Objects with unknown mtime are always considered modified.
+var CompareObjectsByMtime ObjectComparator = func(group *pipeline.Group, src, dst *storage.Object, cfg CompareConfig) (bool, error) {
+	srcMtime, srcOk := objectMtime(src)
+	dstMtime, dstOk := objectMtime(dst)
+	if !srcOk || !dstOk {
+		return true, nil
+	}
+	diff := srcMtime.Sub(dstMtime)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff > cfg.MtimeTolerance, nil
+}
+
+// CompareObjectsBySizeMtime detect modified objects by content size and modification time.
+var CompareObjectsBySizeMtime ObjectComparator = func(group *pipeline.Group, src, dst *storage.Object, cfg CompareConfig) (bool, error) {
+	if modified, err := CompareObjectsBySize(group, src, dst, cfg); modified || err != nil {
+		return modified, err
+	}
+	return CompareObjectsByMtime(group, src, dst, cfg)
+}
+
+// CompareObjectsByChecksum detect modified objects by stored full object checksums (storage.ChecksumAlgorithms).
+// Objects without checksums with the same algorithm are always considered modified,
+// use CompareObjectsByContent for objects without stored checksums.
+var CompareObjectsByChecksum ObjectComparator = func(group *pipeline.Group, src, dst *storage.Object, cfg CompareConfig) (bool, error) {
+	for _, alg := range storage.ChecksumAlgorithms {
+		srcSum := storage.FullObjectChecksum(storage.GetChecksum(src, alg))
+		dstSum := storage.FullObjectChecksum(storage.GetChecksum(dst, alg))
+		if srcSum != nil && dstSum != nil {
+			return *srcSum != *dstSum, nil
+		}
+	}
+	storage.Log.Debugf("Object %s has no checksums to compare, considered modified", *src.Key)
+	return true, nil
+}
+
+// CompareObjectsByContent detect modified objects by SHA-256 of the content.
+// Objects with different sizes are considered modified without reading the content.
+// It reads content of both objects, so it is slow and expensive, but works regardless of
+// multipart uploads, server-side encryption and storage types.
+var CompareObjectsByContent ObjectComparator = func(group *pipeline.Group, src, dst *storage.Object, cfg CompareConfig) (bool, error) {
+	if src.ContentLength != nil && dst.ContentLength != nil && *src.ContentLength != *dst.ContentLength {
+		return true, nil
+	}
+
+	srcSum, err := contentChecksum(group.SourceOf(src), &storage.Object{Key: src.Key, VersionId: src.VersionId})
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := contentChecksum(group.Target, &storage.Object{Key: dst.Key, VersionId: dst.VersionId})
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(srcSum, dstSum), nil
+}
+
+// FilterObjectsModified accepts an input object and checks if it matches the filter
+// This filter read object meta from target storage and compare it with source object using CompareConfig.Comparator.
+// Not modified objects will be skipped, as well as objects with newer target if CompareConfig.NewerOnly is set.
+// Objects that are missing in target storage or failed to load are always processed.
+// For FS storage stored metadata (xattr or sidecar) or ETag computation are required for proper work of ETag comparator.
+//
+// This filter take configuration of CompareConfig type, see pipeline.NewStep.
+// If CompareConfig.Comparator is nil, objects are compared by ETag.
+// If CompareConfig.TargetKey is set, the target object is read by the key it returns.
+var FilterObjectsModified pipeline.TypedStepFn[CompareConfig] = func(group *pipeline.Group, stepNum int, cfg CompareConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	if cfg.Comparator == nil {
+		cfg.Comparator = CompareObjectsByETag
+	}
+	for obj := range input {
+		destObj := &storage.Object{
+			Key:       targetKey(cfg.TargetKey, obj),
+			VersionId: obj.VersionId,
+		}
+		if err := cfg.TargetIndex.GetObjectMeta(group.Target, destObj); err != nil {
+			output <- obj
+			continue
+		}
+
+		modified, err := cfg.Comparator(group, obj, destObj, cfg)
+		if err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+		if modified && cfg.NewerOnly && isTargetNewer(obj, destObj, cfg.MtimeTolerance) {
+			storage.Log.Debugf("Target object %s is newer than source, skipping", *obj.Key)
+			if cfg.State != nil {
+				cfg.State.Discard(obj)
+			}
+			continue
+		}
+		if modified {
+			output <- obj
+		} else if cfg.State != nil {
+			if err := cfg.State.Save(obj); err != nil {
+				errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			}
+		}
+	}
+}
+
+// objectMtime return original object mtime from metadata or object Mtime.
+func objectMtime(obj *storage.Object) (time.Time, bool) {
+	if v, ok := storage.GetMetadata(obj, storage.MetaMtime); ok {
+		if t, err := storage.ParseUnixTime(v); err == nil {
+			return t, true
+		}
+	}
+	if obj.Mtime != nil {
+		return *obj.Mtime, true
+	}
+	return time.Time{}, false
+}
+
+// isTargetNewer check that target object mtime is after source object mtime more than tolerance.
+func isTargetNewer(src, dst *storage.Object, tolerance time.Duration) bool {
+	srcMtime, srcOk := objectMtime(src)
+	dstMtime, dstOk := objectMtime(dst)
+	return srcOk && dstOk && dstMtime.Sub(srcMtime) > tolerance
+}
+
+// contentChecksum load object content from storage and return its SHA-256.
+func contentChecksum(st storage.Storage, obj *storage.Object) ([]byte, error) {
+	if err := st.GetObjectContent(obj); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if obj.ContentStream != nil {
+		defer obj.ContentStream.Close()
+		if _, err := io.Copy(h, obj.ContentStream); err != nil {
+			return nil, err
+		}
+	} else if obj.Content != nil {
+		h.Write(*obj.Content)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,306 @@
+package collection
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Supported compression algorithms. The values are the same as Content-Encoding values.
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+var compressExt = map[string]string{
+	CompressGzip: ".gz",
+	CompressZstd: ".zst",
+}
+
+// CompressConfig is a configuration of CompressObjectData step.
+type CompressConfig struct {
+	// Algorithm is the compression algorithm, CompressGzip or CompressZstd. Used only for compression.
+	Algorithm string
+	// RenameKey add extension (.gz or .zst) to object key instead of setting Content-Encoding.
+	// On decompression objects with this extensions will be decompressed and extension will be removed.
+	// The original key is saved to Object.OriginalKey.
+	RenameKey bool
+	// MinSize is the minimal size of object content to be compressed. Used only for compression.
+	MinSize int64
+	// ContentTypes is a list of Content-Types that will be compressed. Used only for compression.
+	// Values with "*" suffix match by prefix, like "text/*". Empty list means all objects.
+	ContentTypes []string
+}
+
+// Validate check that the compression algorithm is set and supported.
+func (cfg CompressConfig) Validate() error {
+	if cfg.Algorithm == "" {
+		return fmt.Errorf("compression algorithm is not set")
+	}
+	if _, supported := compressExt[cfg.Algorithm]; !supported {
+		return fmt.Errorf("unsupported compression algorithm: %s", cfg.Algorithm)
+	}
+	return nil
+}
+
+// DecompressConfig is a configuration of DecompressObjectData step, see CompressConfig.
+// Only RenameKey is used on decompression.
+type DecompressConfig CompressConfig
+
+// CompressedKey return TargetKeyFunc with the key of the object after CompressObjectData step with given configuration.
+// The object meta used by CompressConfig.MinSize and CompressConfig.ContentTypes should be loaded.
+func CompressedKey(cfg CompressConfig) TargetKeyFunc {
+	return func(obj *storage.Object) string {
+		if cfg.RenameKey && isCompressible(obj, cfg) {
+			return *obj.Key + compressExt[cfg.Algorithm]
+		}
+		return *obj.Key
+	}
+}
+
+// DecompressedKey return TargetKeyFunc with the key of the object after DecompressObjectData step with given configuration.
+func DecompressedKey(cfg DecompressConfig) TargetKeyFunc {
+	return func(obj *storage.Object) string {
+		if cfg.RenameKey && storage.ToValue(obj.ContentEncoding) == "" {
+			for _, ext := range compressExt {
+				if strings.HasSuffix(*obj.Key, ext) {
+					return strings.TrimSuffix(*obj.Key, ext)
+				}
+			}
+		}
+		return *obj.Key
+	}
+}
+
+// CompressObjectData read objects from input, compress its content and send it to next pipeline steps.
+// Objects that already have Content-Encoding, smaller than CompressConfig.MinSize
+// or not matched by CompressConfig.ContentTypes are passed without changes.
+// For ContentStream objects compression is performed on the fly and ContentLength become unknown.
+//
+// This filter take configuration of CompressConfig type, see pipeline.NewStep.
+var CompressObjectData pipeline.TypedStepFn[CompressConfig] = func(group *pipeline.Group, stepNum int, cfg CompressConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		if !isCompressible(obj, cfg) {
+			output <- obj
+			continue
+		}
+		if err := compressObject(obj, cfg); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+		} else {
+			output <- obj
+		}
+	}
+}
+
+// DecompressObjectData read objects from input, decompress its content and send it to next pipeline steps.
+// Objects are decompressed if it has gzip or zstd Content-Encoding,
+// or if DecompressConfig.RenameKey is set and object key has .gz or .zst extension.
+// Other objects are passed without changes.
+//
+// This filter take configuration of DecompressConfig type, see pipeline.NewStep.
+var DecompressObjectData pipeline.TypedStepFn[DecompressConfig] = func(group *pipeline.Group, stepNum int, cfg DecompressConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		if err := decompressObject(obj, cfg); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+		} else {
+			output <- obj
+		}
+	}
+}
+
+func isCompressible(obj *storage.Object, cfg CompressConfig) bool {
+	if storage.ToValue(obj.ContentEncoding) != "" {
+		return false
+	}
+	if obj.ContentLength != nil && *obj.ContentLength < cfg.MinSize {
+		return false
+	}
+	if obj.Content != nil && int64(len(*obj.Content)) < cfg.MinSize {
+		return false
+	}
+	if len(cfg.ContentTypes) == 0 {
+		return true
+	}
+
+	contentType := strings.TrimSpace(strings.SplitN(storage.ToValue(obj.ContentType), ";", 2)[0])
+	for _, ct := range cfg.ContentTypes {
+		if strings.HasSuffix(ct, "*") && strings.HasPrefix(contentType, strings.TrimSuffix(ct, "*")) {
+			return true
+		} else if contentType == ct {
+			return true
+		}
+	}
+	return false
+}
+
+func compressObject(obj *storage.Object, cfg CompressConfig) error {
+	if obj.Content != nil {
+		buf := &bytes.Buffer{}
+		w, err := newCompressWriter(buf, cfg.Algorithm)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(*obj.Content); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		data := buf.Bytes()
+		obj.Content = &data
+		obj.ContentLength = storage.ToPtr(int64(len(data)))
+	} else if obj.ContentStream != nil {
+		src := obj.ContentStream
+		pr, pw := io.Pipe()
+		go func() {
+			defer src.Close()
+			w, err := newCompressWriter(pw, cfg.Algorithm)
+			if err == nil {
+				if _, err = io.Copy(w, src); err == nil {
+					err = w.Close()
+				}
+			}
+			pw.CloseWithError(err)
+		}()
+		obj.ContentStream = &pipeReadCloser{PipeReader: pr}
+		obj.ContentLength = nil
+	} else {
+		return errors.New("object has no content")
+	}
+
+	storage.ClearChecksums(obj)
+	if cfg.RenameKey {
+		if obj.OriginalKey == nil {
+			obj.OriginalKey = obj.Key
+		}
+		obj.Key = storage.ToPtr(*obj.Key + compressExt[cfg.Algorithm])
+	} else {
+		obj.ContentEncoding = storage.ToPtr(cfg.Algorithm)
+	}
+
+	return nil
+}
+
+func decompressObject(obj *storage.Object, cfg DecompressConfig) error {
+	algorithm := ""
+	renamed := false
+	switch strings.ToLower(storage.ToValue(obj.ContentEncoding)) {
+	case CompressGzip, "x-gzip":
+		algorithm = CompressGzip
+	case CompressZstd:
+		algorithm = CompressZstd
+	default:
+		if cfg.RenameKey {
+			for alg, ext := range compressExt {
+				if strings.HasSuffix(*obj.Key, ext) {
+					algorithm = alg
+					renamed = true
+					break
+				}
+			}
+		}
+	}
+	if algorithm == "" {
+		return nil
+	}
+
+	if obj.Content != nil {
+		r, err := newDecompressReader(bytes.NewReader(*obj.Content), algorithm)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		obj.Content = &data
+		obj.ContentLength = storage.ToPtr(int64(len(data)))
+	} else if obj.ContentStream != nil {
+		r, err := newDecompressReader(obj.ContentStream, algorithm)
+		if err != nil {
+			return err
+		}
+		obj.ContentStream = &multiCloser{Reader: r, closers: []io.Closer{r, obj.ContentStream}}
+		obj.ContentLength = nil
+	} else {
+		return errors.New("object has no content")
+	}
+
+	storage.ClearChecksums(obj)
+	if renamed {
+		if obj.OriginalKey == nil {
+			obj.OriginalKey = obj.Key
+		}
+		obj.Key = storage.ToPtr(strings.TrimSuffix(*obj.Key, compressExt[algorithm]))
+	} else {
+		obj.ContentEncoding = nil
+	}
+
+	return nil
+}
+
+func newCompressWriter(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+}
+
+func newDecompressReader(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressGzip:
+		return gzip.NewReader(r)
+	case CompressZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+}
+
+// errStreamClosed is returned to the writer of pipe stream when the reader is closed before EOF.
+var errStreamClosed = errors.New("stream closed by reader")
+
+// pipeReadCloser is the read half of a pipe stream. Close before EOF stops the writer goroutine with errStreamClosed,
+// so it does not block forever when the consumer stops reading, for example on failed upload.
+type pipeReadCloser struct {
+	*io.PipeReader
+}
+
+// Close the pipe reader and stop the writer.
+func (r *pipeReadCloser) Close() error {
+	return r.PipeReader.CloseWithError(errStreamClosed)
+}
+
+// multiCloser is a io.ReadCloser that closes all given closers.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close all closers and return the first error.
+func (m *multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cErr := c.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
@@ -2,6 +2,8 @@
 package collection
 
 import (
+	"time"
+
 	"github.com/larrabee/s3sync/pipeline"
 	"github.com/larrabee/s3sync/storage"
 )
@@ -9,7 +11,7 @@ import (
 // LoadObjectMeta accepts an input object and downloads its metadata.
 var LoadObjectMeta pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		err := group.Source.GetObjectMeta(obj)
+		err := group.SourceOf(obj).GetObjectMeta(obj)
 		if err != nil {
 			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
 		} else {
@@ -21,7 +23,7 @@ var LoadObjectMeta pipeline.StepFn = func(group *pipeline.Group, stepNum int, in
 // LoadObjectData accepts an input object and downloads its content and metadata.
 var LoadObjectData pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		err := group.Source.GetObjectContent(obj)
+		err := group.SourceOf(obj).GetObjectContent(obj)
 		if err != nil {
 			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
 		} else {
@@ -33,10 +35,39 @@ var LoadObjectData pipeline.StepFn = func(group *pipeline.Group, stepNum int, in
 // LoadObjectACL accepts an input object and downloads its ACL.
 var LoadObjectACL pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		err := group.Source.GetObjectACL(obj)
+		err := group.SourceOf(obj).GetObjectACL(obj)
+		if err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+		} else {
+			output <- obj
+		}
+	}
+}
+
+// LoadObjectTags accepts an input object and downloads its tags.
+var LoadObjectTags pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		err := group.SourceOf(obj).GetObjectTags(obj)
+		if err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+		} else {
+			output <- obj
+		}
+	}
+}
+
+// LoadObjectLock accepts an input object and downloads its retention and legal hold.
+// Expired retention is not copied, because target storage rejects retain until date in the past.
+var LoadObjectLock pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		err := group.SourceOf(obj).GetObjectLock(obj)
 		if err != nil {
 			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
 		} else {
+			if obj.ObjectLockRetainUntilDate != nil && !obj.ObjectLockRetainUntilDate.After(time.Now()) {
+				obj.ObjectLockMode = nil
+				obj.ObjectLockRetainUntilDate = nil
+			}
 			output <- obj
 		}
 	}
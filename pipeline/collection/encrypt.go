@@ -0,0 +1,385 @@
+package collection
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"filippo.io/age"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Metadata keys used to store client-side encryption parameters.
+const (
+	CSEMetaAlgorithm = "S3sync-Cse-Algorithm"
+	CSEMetaKeyWrap   = "S3sync-Cse-Key-Wrap"
+	CSEMetaKey       = "S3sync-Cse-Key"
+	CSEMetaSize      = "S3sync-Cse-Size"
+)
+
+// Client-side encryption algorithms.
+const (
+	CSEAlgorithmAESGCMStream = "AES256-GCM-STREAM-64K"
+	CSEKeyWrapAESGCM         = "AES256-GCM"
+	CSEKeyWrapAge            = "AGE"
+)
+
+const (
+	cseKeySize   = 32
+	cseChunkSize = 64 * 1024
+)
+
+// EncryptConfig is a configuration of EncryptObjectData step.
+//
+// Object content is encrypted with a random per-object data key,
+// the data key is wrapped with Key (AES-256-GCM) or with age Recipients and stored in object metadata.
+type EncryptConfig struct {
+	// Key is the 256 bit master key.
+	Key []byte
+	// Recipients are the age recipients used to wrap data keys. If set, Key is not used for encryption.
+	Recipients []age.Recipient
+	// Identities are the age identities used to unwrap data keys on decryption.
+	Identities []age.Identity
+}
+
+// Validate check that the key has valid size and the key or age recipients are set.
+func (cfg EncryptConfig) Validate() error {
+	if len(cfg.Key) != 0 && len(cfg.Key) != cseKeySize {
+		return fmt.Errorf("key must be %d bytes", cseKeySize)
+	}
+	if len(cfg.Key) == 0 && len(cfg.Recipients) == 0 {
+		return fmt.Errorf("key or age recipients must be set")
+	}
+	return nil
+}
+
+// DecryptConfig is a configuration of DecryptObjectData step, see EncryptConfig.
+type DecryptConfig EncryptConfig
+
+// Validate check that the key has valid size and the key or age identities are set.
+func (cfg DecryptConfig) Validate() error {
+	if len(cfg.Key) != 0 && len(cfg.Key) != cseKeySize {
+		return fmt.Errorf("key must be %d bytes", cseKeySize)
+	}
+	if len(cfg.Key) == 0 && len(cfg.Identities) == 0 {
+		return fmt.Errorf("key or age identities must be set")
+	}
+	return nil
+}
+
+// EncryptObjectData read objects from input, encrypt its content and send it to next pipeline steps.
+// Both Content and ContentStream objects are supported, streams are encrypted on the fly.
+//
+// This filter take configuration of EncryptConfig type, see pipeline.NewStep.
+var EncryptObjectData pipeline.TypedStepFn[EncryptConfig] = func(group *pipeline.Group, stepNum int, cfg EncryptConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		if err := encryptObject(obj, cfg); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+		} else {
+			output <- obj
+		}
+	}
+}
+
+// DecryptObjectData read objects from input, decrypt its content and send it to next pipeline steps.
+// Objects without client-side encryption metadata are passed without changes.
+//
+// This filter take configuration of DecryptConfig type, see pipeline.NewStep.
+var DecryptObjectData pipeline.TypedStepFn[DecryptConfig] = func(group *pipeline.Group, stepNum int, cfg DecryptConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		if err := decryptObject(obj, EncryptConfig(cfg)); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+		} else {
+			output <- obj
+		}
+	}
+}
+
+func encryptObject(obj *storage.Object, cfg EncryptConfig) error {
+	dataKey := make([]byte, cseKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+
+	keyWrap, wrappedKey, err := wrapDataKey(dataKey, cfg)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	var plainSize *int64
+	if obj.Content != nil {
+		plainSize = storage.ToPtr(int64(len(*obj.Content)))
+		buf := bytes.NewBuffer(make([]byte, 0, cseEncryptedSize(*plainSize)))
+		if _, err := io.Copy(buf, newCSEReader(bytes.NewReader(*obj.Content), nil, aead, true)); err != nil {
+			return err
+		}
+		data := buf.Bytes()
+		obj.Content = &data
+		obj.ContentLength = storage.ToPtr(int64(len(data)))
+	} else if obj.ContentStream != nil {
+		obj.ContentStream = newCSEReader(obj.ContentStream, obj.ContentStream, aead, true)
+		if obj.ContentLength != nil {
+			plainSize = obj.ContentLength
+			obj.ContentLength = storage.ToPtr(cseEncryptedSize(*plainSize))
+		}
+	} else {
+		return errors.New("object has no content")
+	}
+
+	storage.ClearChecksums(obj)
+	storage.SetMetadata(obj, CSEMetaAlgorithm, CSEAlgorithmAESGCMStream)
+	storage.SetMetadata(obj, CSEMetaKeyWrap, keyWrap)
+	storage.SetMetadata(obj, CSEMetaKey, wrappedKey)
+	if plainSize != nil {
+		storage.SetMetadata(obj, CSEMetaSize, strconv.FormatInt(*plainSize, 10))
+	} else {
+		storage.DeleteMetadata(obj, CSEMetaSize)
+	}
+
+	return nil
+}
+
+func decryptObject(obj *storage.Object, cfg EncryptConfig) error {
+	algorithm, ok := storage.GetMetadata(obj, CSEMetaAlgorithm)
+	if !ok {
+		storage.Log.Debugf("Object %s is not encrypted, skipping decryption", storage.ToValue(obj.Key))
+		return nil
+	}
+	if algorithm != CSEAlgorithmAESGCMStream {
+		return fmt.Errorf("unsupported client-side encryption algorithm: %s", algorithm)
+	}
+
+	keyWrap, _ := storage.GetMetadata(obj, CSEMetaKeyWrap)
+	wrappedKey, _ := storage.GetMetadata(obj, CSEMetaKey)
+	dataKey, err := unwrapDataKey(keyWrap, wrappedKey, cfg)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	if obj.Content != nil {
+		data, err := io.ReadAll(newCSEReader(bytes.NewReader(*obj.Content), nil, aead, false))
+		if err != nil {
+			return err
+		}
+		obj.Content = &data
+		obj.ContentLength = storage.ToPtr(int64(len(data)))
+	} else if obj.ContentStream != nil {
+		obj.ContentStream = newCSEReader(obj.ContentStream, obj.ContentStream, aead, false)
+		obj.ContentLength = nil
+		if size, ok := storage.GetMetadata(obj, CSEMetaSize); ok {
+			if plainSize, err := strconv.ParseInt(size, 10, 64); err == nil {
+				obj.ContentLength = &plainSize
+			}
+		}
+	} else {
+		return errors.New("object has no content")
+	}
+
+	storage.ClearChecksums(obj)
+	for _, key := range []string{CSEMetaAlgorithm, CSEMetaKeyWrap, CSEMetaKey, CSEMetaSize} {
+		storage.DeleteMetadata(obj, key)
+	}
+
+	return nil
+}
+
+func wrapDataKey(dataKey []byte, cfg EncryptConfig) (keyWrap, wrappedKey string, err error) {
+	if len(cfg.Recipients) > 0 {
+		buf := &bytes.Buffer{}
+		w, err := age.Encrypt(buf, cfg.Recipients...)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := w.Write(dataKey); err != nil {
+			return "", "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", "", err
+		}
+		return CSEKeyWrapAge, base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	}
+
+	aead, err := newAESGCM(cfg.Key)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	return CSEKeyWrapAESGCM, base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+func unwrapDataKey(keyWrap, wrappedKey string, cfg EncryptConfig) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped data key: %w", err)
+	}
+
+	var dataKey []byte
+	switch keyWrap {
+	case CSEKeyWrapAge:
+		if len(cfg.Identities) == 0 {
+			return nil, errors.New("object data key is wrapped with age, but no identities are given")
+		}
+		r, err := age.Decrypt(bytes.NewReader(data), cfg.Identities...)
+		if err != nil {
+			return nil, err
+		}
+		if dataKey, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	case CSEKeyWrapAESGCM:
+		if len(cfg.Key) != cseKeySize {
+			return nil, errors.New("object data key is wrapped with AES key, but no key is given")
+		}
+		aead, err := newAESGCM(cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, errors.New("invalid wrapped data key")
+		}
+		if dataKey, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil); err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported client-side encryption key wrap: %s", keyWrap)
+	}
+
+	if len(dataKey) != cseKeySize {
+		return nil, errors.New("invalid data key size")
+	}
+	return dataKey, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cseEncryptedSize return size of encrypted content for given plain content size.
+// Content is split into chunks, each chunk has own auth tag. Empty content is encrypted as one empty chunk.
+func cseEncryptedSize(plainSize int64) int64 {
+	chunks := (plainSize + cseChunkSize - 1) / cseChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return plainSize + chunks*16
+}
+
+// cseReader encrypt or decrypt stream by chunks.
+// Each chunk is sealed with nonce built from chunk counter and last chunk flag,
+// so reordering or truncation of chunks is detected on decryption.
+type cseReader struct {
+	src       io.Reader
+	closer    io.Closer
+	aead      cipher.AEAD
+	encrypt   bool
+	chunkSize int
+	buf       []byte
+	bufLen    int
+	out       []byte
+	outBuf    []byte
+	nonce     []byte
+	counter   uint64
+	done      bool
+}
+
+func newCSEReader(src io.Reader, closer io.Closer, aead cipher.AEAD, encrypt bool) *cseReader {
+	chunkSize := cseChunkSize
+	if !encrypt {
+		chunkSize += aead.Overhead()
+	}
+	return &cseReader{
+		src:       src,
+		closer:    closer,
+		aead:      aead,
+		encrypt:   encrypt,
+		chunkSize: chunkSize,
+		buf:       make([]byte, chunkSize+1),
+		outBuf:    make([]byte, 0, cseChunkSize+aead.Overhead()),
+		nonce:     make([]byte, aead.NonceSize()),
+	}
+}
+
+// nextChunk read next chunk from source and report whether it is the last one.
+// One extra byte is read ahead to detect the end of stream.
+func (r *cseReader) nextChunk() ([]byte, bool, error) {
+	n, err := io.ReadFull(r.src, r.buf[r.bufLen:])
+	n += r.bufLen
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		r.bufLen = 0
+		return r.buf[:n], true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	r.bufLen = 1
+	return r.buf[:r.chunkSize], false, nil
+}
+
+func (r *cseReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		chunk, last, err := r.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+
+		binary.BigEndian.PutUint64(r.nonce[len(r.nonce)-9:], r.counter)
+		r.nonce[len(r.nonce)-1] = 0
+		if last {
+			r.nonce[len(r.nonce)-1] = 1
+		}
+		r.counter++
+
+		if r.encrypt {
+			r.out = r.aead.Seal(r.outBuf[:0], r.nonce, chunk, nil)
+		} else if r.out, err = r.aead.Open(r.outBuf[:0], r.nonce, chunk, nil); err != nil {
+			return 0, errors.New("failed to decrypt object content, data is corrupted or key is invalid")
+		}
+
+		if !last {
+			// Move read ahead byte to the start of buffer.
+			// Chunk data is already processed, so it can be overwritten.
+			r.buf[0] = r.buf[r.chunkSize]
+		}
+		r.done = last
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Close the underlying stream.
+func (r *cseReader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
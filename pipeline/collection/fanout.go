@@ -0,0 +1,265 @@
+package collection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/larrabee/ratelimit"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// FanOutTarget is a named target of UploadObjectDataFanOut step.
+// You should always create new FanOutTarget with NewFanOutTarget constructor.
+type FanOutTarget struct {
+	// Name of the target storage in the group, see pipeline.Group.AddTarget.
+	Name string
+	// ErrorMask is the error handling of the target. Masked errors are logged and counted as skipped,
+	// the object is still uploaded to the other targets, but it is not sent to the next steps.
+	ErrorMask storage.ErrHandlingMask
+	// Stats of the target uploads.
+	Stats  FanOutStats
+	bucket ratelimit.Bucket
+}
+
+// FanOutStats to keep statistics of uploads to a target.
+type FanOutStats struct {
+	Uploaded atomic.Uint64
+	Skipped  atomic.Uint64
+	Failed   atomic.Uint64
+}
+
+// NewFanOutTarget return a new FanOutTarget.
+// rateLimitObjPerSec limit uploads to the target, zero means no limit.
+func NewFanOutTarget(name string, errorMask storage.ErrHandlingMask, rateLimitObjPerSec uint) (*FanOutTarget, error) {
+	target := &FanOutTarget{
+		Name:      name,
+		ErrorMask: errorMask,
+		bucket:    ratelimit.NewFakeBucket(),
+	}
+	if rateLimitObjPerSec > 0 {
+		bucket, err := ratelimit.NewBucketWithRate(float64(rateLimitObjPerSec), int64(rateLimitObjPerSec*2))
+		if err != nil {
+			return nil, err
+		}
+		target.bucket = bucket
+	}
+	return target, nil
+}
+
+// FanOutTargetError is the error of the object upload to a named target.
+type FanOutTargetError struct {
+	Target string
+	Err    error
+}
+
+func (e *FanOutTargetError) Error() string {
+	return fmt.Sprintf("target %s: %s", e.Target, e.Err)
+}
+
+func (e *FanOutTargetError) Unwrap() error {
+	return e.Err
+}
+
+// FanOutConfig is a configuration of UploadObjectDataFanOut step.
+type FanOutConfig struct {
+	// Targets to upload objects to. Empty list means all named targets of the group with default settings.
+	Targets []*FanOutTarget
+}
+
+// UploadObjectDataFanOut read objects from input, put its content and meta to several named target storages
+// and send object to next pipeline steps.
+// Targets are written concurrently. The object content is loaded from source once:
+// content streams are copied to all targets on the fly, so the slowest target limits the speed of others.
+//
+// Objects are sent to next steps only if they are uploaded to all targets, so steps like DeleteSourceObjects
+// never process objects that are missing in one of targets.
+// Errors masked by FanOutTarget.ErrorMask are logged and counted as skipped.
+// Other errors are sent as pipeline.ObjectError with FanOutTargetError.
+// Errors of the content stream reading are sent as pipeline.ObjectError once for all targets.
+//
+// This step take configuration of *FanOutConfig type, see pipeline.NewStep.
+var UploadObjectDataFanOut pipeline.TypedStepFn[*FanOutConfig] = func(group *pipeline.Group, stepNum int, cfg *FanOutConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	ok := true
+	targets := cfg.Targets
+	if len(targets) == 0 {
+		for _, name := range group.TargetNames() {
+			target, _ := NewFanOutTarget(name, 0, 0)
+			targets = append(targets, target)
+		}
+	}
+	var storages []storage.Storage
+	for _, target := range targets {
+		st, found := group.GetTarget(target.Name)
+		if !found {
+			info := group.GetStepInfo(stepNum)
+			errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum, Err: fmt.Errorf("target %s is not found", target.Name)}
+			ok = false
+			break
+		}
+		storages = append(storages, st)
+	}
+
+	for obj := range input {
+		if ok {
+			errs, err := putObjectFanOut(obj, targets, storages)
+			if err != nil {
+				errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+				continue
+			}
+
+			uploaded := true
+			for i, target := range targets {
+				switch {
+				case errs[i] == nil:
+					target.Stats.Uploaded.Add(1)
+				case isErrMasked(target.ErrorMask, errs[i]):
+					target.Stats.Skipped.Add(1)
+					uploaded = false
+					pipeline.Log.Warnf("Skip upload of object %s to target %s, error: %s", *obj.Key, target.Name, errs[i])
+				default:
+					target.Stats.Failed.Add(1)
+					uploaded = false
+					errChan <- &pipeline.ObjectError{Object: obj, Err: &FanOutTargetError{Target: target.Name, Err: errs[i]}}
+				}
+			}
+			if uploaded {
+				output <- obj
+			}
+		}
+	}
+}
+
+// errFanOutTargetsFailed is returned by fanOutWriter when all targets failed.
+var errFanOutTargetsFailed = errors.New("all targets failed")
+
+// putObjectFanOut upload the object to all targets concurrently and return upload errors of each target.
+// The content stream is copied to targets through pipes, the returned error is the error of the stream reading.
+func putObjectFanOut(obj *storage.Object, targets []*FanOutTarget, storages []storage.Storage) ([]error, error) {
+	errs := make([]error, len(targets))
+	wg := sync.WaitGroup{}
+	if obj.ContentStream == nil || len(targets) == 1 {
+		for i := range targets {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				targets[i].bucket.Wait(1)
+				errs[i] = storages[i].PutObject(copyObject(obj))
+			}(i)
+		}
+		wg.Wait()
+		return errs, nil
+	}
+
+	src := obj.ContentStream
+	defer src.Close()
+	w := &fanOutWriter{
+		writers: make([]*io.PipeWriter, len(targets)),
+		failed:  make([]bool, len(targets)),
+	}
+	for i := range targets {
+		pr, pw := io.Pipe()
+		w.writers[i] = pw
+		targetObj := copyObject(obj)
+		targetObj.ContentStream = &fanOutReader{pipeReadCloser: pipeReadCloser{PipeReader: pr}, src: obj, dst: targetObj}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			targets[i].bucket.Wait(1)
+			errs[i] = storages[i].PutObject(targetObj)
+			// Target could return without reading the whole stream, it should not block other targets.
+			targetObj.ContentStream.Close()
+		}(i)
+	}
+
+	_, err := io.Copy(w, src)
+	if errors.Is(err, errFanOutTargetsFailed) {
+		err = nil
+	}
+	for _, pw := range w.writers {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	return errs, err
+}
+
+// fanOutWriter write the content to pipes of all targets. Targets that failed to read are skipped,
+// it fails only if all targets failed.
+type fanOutWriter struct {
+	writers []*io.PipeWriter
+	failed  []bool
+}
+
+// Write p to all not failed targets.
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	active := 0
+	for i, pw := range w.writers {
+		if w.failed[i] {
+			continue
+		}
+		if _, err := pw.Write(p); err != nil {
+			w.failed[i] = true
+			continue
+		}
+		active++
+	}
+	if active == 0 {
+		return 0, errFanOutTargetsFailed
+	}
+	return len(p), nil
+}
+
+// fanOutReader is the content stream of a target object. On EOF it copies checksums of the source object,
+// because checksums of streams are known only after the source stream is read, see ChecksumObjectData.
+type fanOutReader struct {
+	pipeReadCloser
+	src *storage.Object
+	dst *storage.Object
+}
+
+// Read content from the pipe.
+func (r *fanOutReader) Read(p []byte) (int, error) {
+	n, err := r.pipeReadCloser.Read(p)
+	if err == io.EOF {
+		r.dst.ChecksumCRC32C = r.src.ChecksumCRC32C
+		r.dst.ChecksumSHA256 = r.src.ChecksumSHA256
+	}
+	return n, err
+}
+
+// copyObject return a shallow copy of the object with own Metadata and Tags maps,
+// so the target storage can't affect the uploads to other targets.
+func copyObject(obj *storage.Object) *storage.Object {
+	res := *obj
+	if obj.Metadata != nil {
+		res.Metadata = make(map[string]*string, len(obj.Metadata))
+		for k, v := range obj.Metadata {
+			res.Metadata[k] = v
+		}
+	}
+	if obj.Tags != nil {
+		res.Tags = make(map[string]*string, len(obj.Tags))
+		for k, v := range obj.Tags {
+			res.Tags[k] = v
+		}
+	}
+	return &res
+}
+
+// isErrMasked check that the error is handled by the error handling mask.
+func isErrMasked(mask storage.ErrHandlingMask, err error) bool {
+	switch {
+	case storage.IsErrNotExist(err):
+		return mask.Has(storage.HandleErrNotExist)
+	case storage.IsErrPermission(err):
+		return mask.Has(storage.HandleErrPermission)
+	case storage.IsErrChecksumMismatch(err):
+		return mask.Has(storage.HandleErrChecksum)
+	default:
+		return mask.Has(storage.HandleErrOther)
+	}
+}
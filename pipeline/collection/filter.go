@@ -10,130 +10,95 @@ import (
 // FilterObjectsByExt accepts an input object and checks if it matches the filter.
 // This filter skips objects with extensions that are not specified in the config.
 //
-// This filter read configuration from Step.Config and assert it type to []string type.
-var FilterObjectsByExt pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.([]string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+// This filter take configuration of []string type, see pipeline.NewStep.
+var FilterObjectsByExt pipeline.TypedStepFn[[]string] = func(group *pipeline.Group, stepNum int, cfg []string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			flag := false
-			fileExt := filepath.Ext(*obj.Key)
-			for _, ext := range cfg {
-				if fileExt == ext {
-					flag = true
-					break
-				}
-			}
-			if flag {
-				output <- obj
+		flag := false
+		fileExt := filepath.Ext(*obj.Key)
+		for _, ext := range cfg {
+			if fileExt == ext {
+				flag = true
+				break
 			}
 		}
+		if flag {
+			output <- obj
+		}
 	}
 }
 
 // FilterObjectsByExtNot accepts an input object and checks if it matches the filter.
 // This filter skips objects with extensions that are specified in the config.
 //
-// This filter read configuration from Step.Config and assert it type to []string type.
-var FilterObjectsByExtNot pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.([]string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+// This filter take configuration of []string type, see pipeline.NewStep.
+var FilterObjectsByExtNot pipeline.TypedStepFn[[]string] = func(group *pipeline.Group, stepNum int, cfg []string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			flag := false
-			fileExt := filepath.Ext(*obj.Key)
-			for _, ext := range cfg {
-				if fileExt == ext {
-					flag = true
-					break
-				}
-			}
-			if !flag {
-				output <- obj
+		flag := false
+		fileExt := filepath.Ext(*obj.Key)
+		for _, ext := range cfg {
+			if fileExt == ext {
+				flag = true
+				break
 			}
 		}
+		if !flag {
+			output <- obj
+		}
 	}
 }
 
 // FilterObjectsByCT accepts an input object and checks if it matches the filter.
 // This filter skips objects with Content-Type that are not specified in the config.
 //
-// This filter read configuration from Step.Config and assert it type to []string type.
-var FilterObjectsByCT pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.([]string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+// This filter take configuration of []string type, see pipeline.NewStep.
+var FilterObjectsByCT pipeline.TypedStepFn[[]string] = func(group *pipeline.Group, stepNum int, cfg []string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			flag := false
-			for _, ct := range cfg {
-				if obj.ContentType == nil && ct == "" {
-					flag = true
-					break
-				} else if obj.ContentType != nil && *obj.ContentType == ct {
-					flag = true
-					break
-				}
-			}
-			if flag {
-				output <- obj
+		flag := false
+		for _, ct := range cfg {
+			if obj.ContentType == nil && ct == "" {
+				flag = true
+				break
+			} else if obj.ContentType != nil && *obj.ContentType == ct {
+				flag = true
+				break
 			}
 		}
+		if flag {
+			output <- obj
+		}
 	}
 }
 
 // FilterObjectsByCTNot accepts an input object and checks if it matches the filter.
 // This filter skips objects with Content-Type that are specified in the config.
 //
-// This filter read configuration from Step.Config and assert it type to []string type.
-var FilterObjectsByCTNot pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.([]string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+// This filter take configuration of []string type, see pipeline.NewStep.
+var FilterObjectsByCTNot pipeline.TypedStepFn[[]string] = func(group *pipeline.Group, stepNum int, cfg []string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			flag := false
-			for _, ct := range cfg {
-				if obj.ContentType == nil && ct == "" {
-					flag = true
-					break
-				} else if obj.ContentType != nil && *obj.ContentType == ct {
-					flag = true
-					break
-				}
-			}
-			if !flag {
-				output <- obj
+		flag := false
+		for _, ct := range cfg {
+			if obj.ContentType == nil && ct == "" {
+				flag = true
+				break
+			} else if obj.ContentType != nil && *obj.ContentType == ct {
+				flag = true
+				break
 			}
 		}
+		if !flag {
+			output <- obj
+		}
 	}
 }
 
 // FilterObjectsByMtimeAfter accepts an input object and checks if it matches the filter.
 // This filter accepts objects that modified after given unix timestamp.
 //
-// This filter read configuration from Step.Config and assert it type to int64 type.
-var FilterObjectsByMtimeAfter pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(int64)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+// This filter take configuration of int64 type, see pipeline.NewStep.
+var FilterObjectsByMtimeAfter pipeline.TypedStepFn[int64] = func(group *pipeline.Group, stepNum int, cfg int64, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			if obj.Mtime.Unix() >= cfg {
-				output <- obj
-			}
+		if obj.Mtime.Unix() >= cfg {
+			output <- obj
 		}
 	}
 }
@@ -141,46 +106,33 @@ var FilterObjectsByMtimeAfter pipeline.StepFn = func(group *pipeline.Group, step
 // FilterObjectsByMtimeBefore accepts an input object and checks if it matches the filter.
 // This filter accepts objects that modified before given unix timestamp.
 //
-// This filter read configuration from Step.Config and assert it type to int64 type.
-var FilterObjectsByMtimeBefore pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(int64)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+// This filter take configuration of int64 type, see pipeline.NewStep.
+var FilterObjectsByMtimeBefore pipeline.TypedStepFn[int64] = func(group *pipeline.Group, stepNum int, cfg int64, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			if obj.Mtime.Unix() < cfg {
-				output <- obj
-			}
+		if obj.Mtime.Unix() < cfg {
+			output <- obj
 		}
 	}
 }
 
-// FilterObjectsModified accepts an input object and checks if it matches the filter
-// This filter read object meta from target storage and compare object ETags. If Etags are equal object will be skipped
-// For FS storage xattr support are required for proper work.
-var FilterObjectsModified pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	for obj := range input {
-		destObj := &storage.Object{
-			Key:       obj.Key,
-			VersionId: obj.VersionId,
-		}
-		err := group.Target.GetObjectMeta(destObj)
-		if (err != nil) || (obj.ETag == nil || destObj.ETag == nil) || (*obj.ETag != *destObj.ETag) {
-			output <- obj
-		}
-	}
+// ExistConfig is a configuration of FilterObjectsExist and FilterObjectsExistNot steps.
+type ExistConfig struct {
+	// TargetIndex is used to look up target objects in the target listing. If nil, target GetObjectMeta is used.
+	TargetIndex *TargetIndex
+	// TargetKey return the key of the object in target storage. If nil, the source key is used.
+	TargetKey TargetKeyFunc
 }
 
 // FilterObjectsExist accepts an input object and checks if it exist in target storage
 // This filter read object meta from target storage. Object will be processed only when it exist in target storage.
-var FilterObjectsExist pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+//
+// This filter take configuration of ExistConfig type, see pipeline.NewStep.
+var FilterObjectsExist pipeline.TypedStepFn[ExistConfig] = func(group *pipeline.Group, stepNum int, cfg ExistConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
 		destObj := &storage.Object{
-			Key: obj.Key,
+			Key: targetKey(cfg.TargetKey, obj),
 		}
-		err := group.Target.GetObjectMeta(destObj)
+		err := cfg.TargetIndex.CheckObjectExist(group.Target, destObj)
 		if err == nil {
 			output <- obj
 		} else if storage.IsErrNotExist(err) {
@@ -191,14 +143,16 @@ var FilterObjectsExist pipeline.StepFn = func(group *pipeline.Group, stepNum int
 	}
 }
 
-// FilterObjectsExist accepts an input object and checks if it exist in target storage
+// FilterObjectsExistNot accepts an input object and checks if it exist in target storage
 // This filter read object meta from target storage. Object will be processed only when it doesn't exist in target storage.
-var FilterObjectsExistNot pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+//
+// This filter take configuration of ExistConfig type, see pipeline.NewStep.
+var FilterObjectsExistNot pipeline.TypedStepFn[ExistConfig] = func(group *pipeline.Group, stepNum int, cfg ExistConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
 		destObj := &storage.Object{
-			Key: obj.Key,
+			Key: targetKey(cfg.TargetKey, obj),
 		}
-		err := group.Target.GetObjectMeta(destObj)
+		err := cfg.TargetIndex.CheckObjectExist(group.Target, destObj)
 		if err == nil {
 			continue
 		} else if storage.IsErrNotExist(err) {
@@ -0,0 +1,322 @@
+package collection
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/state"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// ObjectIndex is the in-memory index of listed objects by key.
+// You should always create new ObjectIndex with NewObjectIndex constructor.
+// Keys of objects that failed to load can be marked as skipped, so the index consumers don't treat them as missing.
+type ObjectIndex struct {
+	mu         sync.RWMutex
+	objects    map[string]*storage.Object
+	skipped    map[string]bool
+	incomplete bool
+}
+
+// NewObjectIndex return a new ObjectIndex.
+func NewObjectIndex() *ObjectIndex {
+	return &ObjectIndex{
+		objects: make(map[string]*storage.Object),
+		skipped: make(map[string]bool),
+	}
+}
+
+// Skip mark the key of the object that failed to load and was skipped.
+func (idx *ObjectIndex) Skip(key string) {
+	idx.mu.Lock()
+	idx.skipped[key] = true
+	idx.mu.Unlock()
+}
+
+// IsSkipped check that the key is marked as skipped.
+func (idx *ObjectIndex) IsSkipped(key string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.skipped[key]
+}
+
+// SetIncomplete mark the index as incomplete, it is used when skipped error is not related to an object, like listing error.
+func (idx *ObjectIndex) SetIncomplete() {
+	idx.mu.Lock()
+	idx.incomplete = true
+	idx.mu.Unlock()
+}
+
+// Incomplete check that the index is marked as incomplete.
+func (idx *ObjectIndex) Incomplete() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.incomplete
+}
+
+// Add put the object to the index.
+func (idx *ObjectIndex) Add(obj *storage.Object) {
+	idx.mu.Lock()
+	idx.objects[*obj.Key] = obj
+	idx.mu.Unlock()
+}
+
+// Get return the object by key.
+func (idx *ObjectIndex) Get(key string) (*storage.Object, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	obj, ok := idx.objects[key]
+	return obj, ok
+}
+
+// Keys return all keys sorted.
+func (idx *ObjectIndex) Keys() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	keys := make([]string, 0, len(idx.objects))
+	for key := range idx.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len return the number of objects in the index.
+func (idx *ObjectIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.objects)
+}
+
+// IndexObjects read objects from input, add them to ObjectIndex and send objects to next pipeline steps.
+//
+// This step take configuration of *ObjectIndex type, see pipeline.NewStep.
+var IndexObjects pipeline.TypedStepFn[*ObjectIndex] = func(group *pipeline.Group, stepNum int, cfg *ObjectIndex, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		cfg.Add(obj)
+		output <- obj
+	}
+}
+
+// ErrNotInTargetIndex raises when object is not found in the target listing of TargetIndex.
+var ErrNotInTargetIndex = fmt.Errorf("object is not found in target listing: %w", os.ErrNotExist)
+
+// TargetIndex answer target object lookups of filter steps from the target storage listing
+// instead of per-object GetObjectMeta requests.
+// The target is listed on demand, once the number of lookups exceeds the Threshold,
+// so small syncs keep using GetObjectMeta and large ones list target once.
+// The choice does not depend on the target size: a small source synced to a huge target
+// should use a high Threshold or GetObjectMeta only (nil TargetIndex).
+// Storages implementing storage.ParallelLister are listed with ListWorkers concurrent requests,
+// other storages are listed sequentially. Lookups wait for the listing to be completed.
+// Objects with VersionId are always looked up with GetObjectMeta.
+//
+// Up to MaxObjects listed objects are kept in memory, it takes about 0.5KB per object.
+// Larger listings are spilled to a temporary bbolt database in SpillDir, lookups of spilled index are slower,
+// but still much faster than GetObjectMeta requests. The database file is removed right after creation,
+// so it is deleted by OS when the process exits.
+//
+// You should always create new TargetIndex with NewTargetIndex constructor.
+type TargetIndex struct {
+	// Threshold is the number of lookups with GetObjectMeta before the target listing is started.
+	// Zero means that target is listed on the first lookup.
+	Threshold uint64
+	// UseListedMeta allow to use listed objects as objects with loaded meta.
+	// Set it only if target listing contains all the meta required by comparator (like ETag and size of S3 listing).
+	// Otherwise, GetObjectMeta is called for objects found in listing.
+	UseListedMeta bool
+	// MaxObjects is the max number of objects kept in memory, the index is spilled to disk if the target is larger.
+	// Zero means no limit.
+	MaxObjects uint64
+	// SpillDir is the dir of spilled index database. If empty, the default dir for temporary files is used.
+	SpillDir string
+	// ListWorkers is the number of concurrent listing requests for storages implementing storage.ParallelLister.
+	ListWorkers int
+
+	lookups uint64
+	once    sync.Once
+	index   *ObjectIndex
+	spilled *state.DB[indexEntry]
+	err     error
+}
+
+// indexEntry is the listed object meta stored in the spilled index.
+type indexEntry struct {
+	ETag          *string    `json:"e,omitempty"`
+	Mtime         *time.Time `json:"m,omitempty"`
+	ContentLength *int64     `json:"l,omitempty"`
+	StorageClass  *string    `json:"c,omitempty"`
+}
+
+// NewTargetIndex return a new TargetIndex.
+func NewTargetIndex(threshold uint64, useListedMeta bool) *TargetIndex {
+	return &TargetIndex{
+		Threshold:     threshold,
+		UseListedMeta: useListedMeta,
+		index:         NewObjectIndex(),
+	}
+}
+
+// Validate accept nil TargetIndex, it means that GetObjectMeta is always used.
+func (idx *TargetIndex) Validate() error {
+	return nil
+}
+
+// GetObjectMeta load meta of the object from target storage like Storage.GetObjectMeta, but answer from the target listing if it is used.
+// It returns ErrNotInTargetIndex if the object is not found in target listing.
+// If idx is nil, target GetObjectMeta is always used.
+func (idx *TargetIndex) GetObjectMeta(target storage.Storage, obj *storage.Object) error {
+	return idx.lookup(target, obj, true)
+}
+
+// CheckObjectExist check that the object exists in target storage like GetObjectMeta,
+// but object meta is not loaded if the object is found in target listing.
+func (idx *TargetIndex) CheckObjectExist(target storage.Storage, obj *storage.Object) error {
+	return idx.lookup(target, obj, false)
+}
+
+func (idx *TargetIndex) lookup(target storage.Storage, obj *storage.Object, needMeta bool) error {
+	if idx == nil || obj.VersionId != nil || atomic.AddUint64(&idx.lookups, 1) <= idx.Threshold {
+		return target.GetObjectMeta(obj)
+	}
+
+	idx.once.Do(func() {
+		idx.load(target)
+	})
+	if idx.err != nil {
+		return target.GetObjectMeta(obj)
+	}
+
+	listed, ok := idx.get(*obj.Key)
+	if !ok {
+		return ErrNotInTargetIndex
+	}
+	if !needMeta {
+		return nil
+	}
+	if !idx.UseListedMeta {
+		return target.GetObjectMeta(obj)
+	}
+	obj.ETag = listed.ETag
+	obj.Mtime = listed.Mtime
+	obj.ContentLength = listed.ContentLength
+	obj.StorageClass = listed.StorageClass
+	return nil
+}
+
+// get return the listed object from memory or spilled index.
+func (idx *TargetIndex) get(key string) (*storage.Object, bool) {
+	if idx.spilled == nil {
+		return idx.index.Get(key)
+	}
+	entry, ok := idx.spilled.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return &storage.Object{
+		Key:           &key,
+		ETag:          entry.ETag,
+		Mtime:         entry.Mtime,
+		ContentLength: entry.ContentLength,
+		StorageClass:  entry.StorageClass,
+	}, true
+}
+
+// add put the listed object to the index, objects are moved to the spilled index when MaxObjects is reached.
+func (idx *TargetIndex) add(obj *storage.Object) error {
+	if idx.spilled == nil && idx.MaxObjects > 0 && uint64(idx.index.Len()) >= idx.MaxObjects {
+		if err := idx.spill(); err != nil {
+			return err
+		}
+	}
+	if idx.spilled == nil {
+		idx.index.Add(obj)
+		return nil
+	}
+	return idx.spilled.Put(*obj.Key, indexEntry{
+		ETag:          obj.ETag,
+		Mtime:         obj.Mtime,
+		ContentLength: obj.ContentLength,
+		StorageClass:  obj.StorageClass,
+	})
+}
+
+// spill create the index database in SpillDir and move objects from memory to it.
+func (idx *TargetIndex) spill() error {
+	f, err := os.CreateTemp(idx.SpillDir, "s3sync-target-index-*.db")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	f.Close()
+	db, err := state.Open[indexEntry](path)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	// The opened database is still usable, the file is deleted by OS when it is closed.
+	if err := os.Remove(path); err != nil {
+		storage.Log.Warnf("Failed to remove target index file %s, error: %s", path, err)
+	}
+	storage.Log.Infof("Target listing has more than %d objects, spill it to disk", idx.MaxObjects)
+
+	idx.spilled = db
+	for _, key := range idx.index.Keys() {
+		obj, _ := idx.index.Get(key)
+		if err := idx.add(obj); err != nil {
+			return err
+		}
+	}
+	idx.index = NewObjectIndex()
+	return nil
+}
+
+// load list target storage to the index.
+// If listing failed, the error is logged and lookups fall back to GetObjectMeta.
+func (idx *TargetIndex) load(target storage.Storage) {
+	storage.Log.Infof("Start target listing for target objects lookup")
+	defer func() {
+		if idx.err != nil && idx.spilled != nil {
+			idx.spilled.Close()
+			idx.spilled = nil
+		}
+	}()
+
+	listChan := make(chan *storage.Object, 1000)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(listChan)
+		if lister, ok := target.(storage.ParallelLister); ok && idx.ListWorkers > 1 {
+			listErr <- lister.ListParallel(listChan, idx.ListWorkers)
+		} else {
+			listErr <- target.List(listChan)
+		}
+	}()
+	count := 0
+	for obj := range listChan {
+		if idx.err != nil {
+			// Storage listing can't be interrupted, so the rest of it is skipped.
+			continue
+		}
+		if idx.err = idx.add(obj); idx.err != nil {
+			storage.Log.Warnf("Failed to save target listing, fallback to per-object requests, error: %s", idx.err)
+		}
+		count++
+	}
+	if idx.err != nil {
+		return
+	}
+	if idx.err = <-listErr; idx.err == nil && idx.spilled != nil {
+		idx.err = idx.spilled.Flush()
+	}
+	if idx.err != nil {
+		storage.Log.Warnf("Target listing failed, fallback to per-object requests, error: %s", idx.err)
+		return
+	}
+	storage.Log.Infof("Target listing completed, %d objects found", count)
+}
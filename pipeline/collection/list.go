@@ -1,6 +1,8 @@
 package collection
 
 import (
+	"fmt"
+
 	"github.com/larrabee/s3sync/pipeline"
 	"github.com/larrabee/s3sync/storage"
 )
@@ -12,3 +14,97 @@ var ListSourceStorage pipeline.StepFn = func(group *pipeline.Group, stepNum int,
 		errChan <- err
 	}
 }
+
+// ListObjects send configured objects to next pipeline steps instead of listing source storage.
+//
+// This step take configuration of []*storage.Object type, see pipeline.NewStep.
+var ListObjects pipeline.TypedStepFn[[]*storage.Object] = func(group *pipeline.Group, stepNum int, cfg []*storage.Object, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for _, obj := range cfg {
+		output <- obj
+	}
+}
+
+// KeyConflictError raises when objects listed from different sources have the same key.
+type KeyConflictError struct {
+	Key    string
+	Origin string
+	Other  string
+}
+
+func (e *KeyConflictError) Error() string {
+	return fmt.Sprintf("key %s of source %s conflicts with the object of source %s", e.Key, e.Origin, e.Other)
+}
+
+// MergeConfig is a configuration of ListMergedSources step.
+// You should always create new MergeConfig with NewMergeConfig constructor.
+type MergeConfig struct {
+	// Sources are names of listed sources in the precedence order. Empty list means all named sources of the group sorted by name.
+	Sources []string
+	keys    map[string]string
+}
+
+// NewMergeConfig return a new MergeConfig.
+func NewMergeConfig(sources ...string) *MergeConfig {
+	return &MergeConfig{
+		Sources: sources,
+		keys:    make(map[string]string),
+	}
+}
+
+// checkConflict save the key of the source and return the name of another source with the same key.
+func (cfg *MergeConfig) checkConflict(key, origin string) string {
+	if other, ok := cfg.keys[key]; ok && other != origin {
+		return other
+	}
+	cfg.keys[key] = origin
+	return ""
+}
+
+// ListMergedSources list named sources of the group one by one and send merged listing to next pipeline steps.
+// Listed objects are tagged with the source name in Object.Origin, so the steps reading source storage use
+// the right one (see pipeline.Group.SourceOf).
+// Use storage.PrefixStorage to put keys of a source under its own prefix.
+//
+// Sources are listed in MergeConfig.Sources order, so the precedence of sources is deterministic:
+// objects with the key already listed from a previous source are not sent, KeyConflictError is returned for them.
+// All listed keys are kept in memory for this check.
+//
+// This step take configuration of *MergeConfig type, see pipeline.NewStep.
+var ListMergedSources pipeline.TypedStepFn[*MergeConfig] = func(group *pipeline.Group, stepNum int, cfg *MergeConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	names := cfg.Sources
+	if len(names) == 0 {
+		names = group.SourceNames()
+	}
+	sources := make([]storage.Storage, len(names))
+	for i, name := range names {
+		st, found := group.GetSource(name)
+		if !found {
+			errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum, Err: fmt.Errorf("source %s is not found", name)}
+			return
+		}
+		sources[i] = st
+	}
+
+	for i, name := range names {
+		listChan := make(chan *storage.Object)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for obj := range listChan {
+				obj.Origin = name
+				if other := cfg.checkConflict(*obj.Key, name); other != "" {
+					errChan <- &pipeline.ObjectError{Object: obj, Err: &KeyConflictError{Key: *obj.Key, Origin: name, Other: other}}
+					continue
+				}
+				output <- obj
+			}
+		}()
+		err := sources[i].List(listChan)
+		close(listChan)
+		<-done
+		if err != nil {
+			errChan <- fmt.Errorf("source %s: %w", name, err)
+		}
+	}
+}
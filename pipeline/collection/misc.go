@@ -1,6 +1,9 @@
 package collection
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/larrabee/ratelimit"
 	"github.com/sirupsen/logrus"
 
@@ -19,106 +22,141 @@ var Terminator pipeline.StepFn = func(group *pipeline.Group, stepNum int, input
 }
 
 // Logger read objects from input, print object name with Log and send object no next pipeline steps.
-var Logger pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(*logrus.Logger)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+var Logger pipeline.TypedStepFn[*logrus.Logger] = func(group *pipeline.Group, stepNum int, cfg *logrus.Logger, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			cfg.WithFields(logrus.Fields{
-				"key":              storage.ToValue(obj.Key),
-				"size":             storage.ToValue(obj.ContentLength),
-				"Content-Type":     storage.ToValue(obj.ContentType),
-				"Content-Encoding": storage.ToValue(obj.ContentEncoding),
-			}).Infof("Sync file")
-			output <- obj
+		fields := logrus.Fields{
+			"key":              storage.ToValue(obj.Key),
+			"size":             storage.ToValue(obj.ContentLength),
+			"Content-Type":     storage.ToValue(obj.ContentType),
+			"Content-Encoding": storage.ToValue(obj.ContentEncoding),
+		}
+		if obj.OriginalKey != nil {
+			fields["original_key"] = *obj.OriginalKey
 		}
+		cfg.WithFields(fields).Infof("Sync file")
+		output <- obj
 	}
 }
 
 // ACLUpdater read objects from input and update its ACL.
-// This filter read configuration from Step.Config and assert it type to string type.
+// This filter take configuration of string type, see pipeline.NewStep.
 // ACL is S3 attribute, its not related with FS permissions.
-var ACLUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
+var ACLUpdater pipeline.TypedStepFn[string] = func(group *pipeline.Group, stepNum int, cfg string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			obj.ACL = &cfg
-			output <- obj
-		}
+		obj.ACL = &cfg
+		output <- obj
 	}
 }
 
 // StorageClassUpdater read objects from input and update its Storage Class.
-// This filter read configuration from Step.Config and assert it type to string type.
-var StorageClassUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+// This filter take configuration of string type, see pipeline.NewStep.
+var StorageClassUpdater pipeline.TypedStepFn[string] = func(group *pipeline.Group, stepNum int, cfg string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		obj.StorageClass = &cfg
+		output <- obj
 	}
+}
+
+// TagsUpdater read objects from input and set its tags.
+// Tags from config override object tags with the same key, other object tags are kept.
+// This filter take configuration of map[string]*string type, see pipeline.NewStep.
+var TagsUpdater pipeline.TypedStepFn[map[string]*string] = func(group *pipeline.Group, stepNum int, cfg map[string]*string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			obj.StorageClass = &cfg
-			output <- obj
+		if obj.Tags == nil {
+			obj.Tags = make(map[string]*string, len(cfg))
+		}
+		for k, v := range cfg {
+			obj.Tags[k] = v
 		}
+		output <- obj
 	}
 }
 
-// CacheControlUpdater updates the cache control.
-var CacheControlUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+// ObjectLockConfig is a configuration of ObjectLockUpdater step.
+// Empty Mode or LegalHoldStatus mean that the object value will not be changed.
+type ObjectLockConfig struct {
+	Mode            string
+	RetainPeriod    time.Duration
+	LegalHoldStatus string
+}
+
+// Validate check that Mode and LegalHoldStatus have valid values and RetainPeriod is set with Mode.
+func (cfg ObjectLockConfig) Validate() error {
+	switch cfg.Mode {
+	case "":
+		if cfg.RetainPeriod != 0 {
+			return fmt.Errorf("retain period require object lock mode")
+		}
+	case "GOVERNANCE", "COMPLIANCE":
+		if cfg.RetainPeriod <= 0 {
+			return fmt.Errorf("object lock mode require positive retain period")
+		}
+	default:
+		return fmt.Errorf("unsupported object lock mode: %s", cfg.Mode)
+	}
+	switch cfg.LegalHoldStatus {
+	case "", "ON", "OFF":
+	default:
+		return fmt.Errorf("unsupported legal hold status: %s", cfg.LegalHoldStatus)
 	}
+	return nil
+}
+
+// ObjectLockUpdater read objects from input and update its retention and legal hold.
+// Retain until date is calculated as the current time plus ObjectLockConfig.RetainPeriod.
+// This filter take configuration of ObjectLockConfig type, see pipeline.NewStep.
+var ObjectLockUpdater pipeline.TypedStepFn[ObjectLockConfig] = func(group *pipeline.Group, stepNum int, cfg ObjectLockConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			obj.CacheControl = &cfg
-			output <- obj
+		if cfg.Mode != "" {
+			obj.ObjectLockMode = storage.ToPtr(cfg.Mode)
+			obj.ObjectLockRetainUntilDate = storage.ToPtr(time.Now().Add(cfg.RetainPeriod))
 		}
+		if cfg.LegalHoldStatus != "" {
+			obj.ObjectLockLegalHoldStatus = storage.ToPtr(cfg.LegalHoldStatus)
+		}
+		output <- obj
 	}
 }
 
-// ServerSideEncryptionUpdater updates the SSE mode.
-var ServerSideEncryptionUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(string)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+// CacheControlUpdater updates the cache control.
+var CacheControlUpdater pipeline.TypedStepFn[string] = func(group *pipeline.Group, stepNum int, cfg string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		obj.CacheControl = &cfg
+		output <- obj
 	}
+}
+
+// ServerSideEncryptionUpdater updates the SSE mode.
+var ServerSideEncryptionUpdater pipeline.TypedStepFn[string] = func(group *pipeline.Group, stepNum int, cfg string, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	for obj := range input {
-		if ok {
-			obj.ServerSideEncryption = &cfg
-			output <- obj
-		}
+		obj.ServerSideEncryption = &cfg
+		output <- obj
 	}
 }
 
+// RateLimitConfig is a configuration of PipelineRateLimit step, the rate in objects per second.
+type RateLimitConfig uint
+
+// Validate check that the rate is not zero and the rate limit bucket can be created.
+func (cfg RateLimitConfig) Validate() error {
+	if cfg == 0 {
+		return fmt.Errorf("rate limit must be greater than zero")
+	}
+	_, err := cfg.bucket()
+	return err
+}
+
+func (cfg RateLimitConfig) bucket() (ratelimit.Bucket, error) {
+	return ratelimit.NewBucketWithRate(float64(cfg), int64(cfg*2))
+}
+
 // PipelineRateLimit read objects from input and slow down pipeline processing speed to given rate (obj/sec).
 //
-// This filter read configuration from Step.Config and assert it type to uint type.
-var PipelineRateLimit pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(uint)
-	if !ok {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
-	}
-	bucket, err := ratelimit.NewBucketWithRate(float64(cfg), int64(cfg*2))
-	if err != nil {
-		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum, Err: err}
-		ok = false
-	}
+// This filter take configuration of RateLimitConfig type, see pipeline.NewStep.
+var PipelineRateLimit pipeline.TypedStepFn[RateLimitConfig] = func(group *pipeline.Group, stepNum int, cfg RateLimitConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	bucket, _ := cfg.bucket() // checked by RateLimitConfig.Validate
 	for obj := range input {
-		if ok {
-			bucket.Wait(1)
-			output <- obj
-		}
+		bucket.Wait(1)
+		output <- obj
 	}
 }
@@ -0,0 +1,75 @@
+package collection
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// MoveConfig is a configuration of DeleteSourceObjects step.
+type MoveConfig struct {
+	// Storage is used to delete source objects. If nil, the delete storage of the object source is used, see pipeline.Group.DeleteSourceOf.
+	// Pass the source storage without cancellable context to avoid interrupted deletes on sync abort.
+	Storage storage.Storage
+	// Verify check that target object exists and has the same size and checksums as the uploaded one
+	// before deleting the source object.
+	Verify bool
+}
+
+// DeleteSourceObjects read uploaded objects from input, delete them from source storage and send objects to next pipeline steps.
+// It should be placed right after UploadObjectData step, which passes only successfully uploaded objects.
+// Source object is deleted by Object.OriginalKey if the key was rewritten.
+// Deleted keys are logged with pipeline.Log.
+//
+// This step take configuration of MoveConfig type, see pipeline.NewStep.
+var DeleteSourceObjects pipeline.TypedStepFn[MoveConfig] = func(group *pipeline.Group, stepNum int, cfg MoveConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		st := cfg.Storage
+		if st == nil {
+			st = group.DeleteSourceOf(obj)
+		}
+		if cfg.Verify {
+			if err := verifyUploadedObject(group.Target, obj); err != nil {
+				errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+				continue
+			}
+		}
+
+		srcKey := obj.Key
+		if obj.OriginalKey != nil {
+			srcKey = obj.OriginalKey
+		}
+		if err := st.DeleteObject(&storage.Object{Key: srcKey, VersionId: obj.VersionId}); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+		pipeline.Log.WithFields(logrus.Fields{
+			"key":        *srcKey,
+			"target_key": *obj.Key,
+		}).Info("Delete source object")
+		output <- obj
+	}
+}
+
+// verifyUploadedObject load target object meta and compare it with the uploaded object.
+// Size and full object checksums are compared only if they are known on both sides.
+func verifyUploadedObject(target storage.Storage, obj *storage.Object) error {
+	destObj := &storage.Object{Key: obj.Key}
+	if err := target.GetObjectMeta(destObj); err != nil {
+		return err
+	}
+	if obj.ContentLength != nil && destObj.ContentLength != nil && *obj.ContentLength != *destObj.ContentLength {
+		return fmt.Errorf("target object size %d does not match uploaded size %d", *destObj.ContentLength, *obj.ContentLength)
+	}
+	for _, alg := range storage.ChecksumAlgorithms {
+		expected := storage.FullObjectChecksum(storage.GetChecksum(obj, alg))
+		actual := storage.FullObjectChecksum(storage.GetChecksum(destObj, alg))
+		if expected != nil && actual != nil && *expected != *actual {
+			return &storage.ChecksumMismatchError{Algorithm: alg, Expected: *expected, Actual: *actual}
+		}
+	}
+	return nil
+}
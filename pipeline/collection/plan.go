@@ -0,0 +1,123 @@
+package collection
+
+import (
+	"sync"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Actions of PlanEntry.
+const (
+	// PlanUpload means the object would be uploaded to the target storage.
+	PlanUpload = "upload"
+	// PlanOverwrite means the object would overwrite the existing target object.
+	PlanOverwrite = "overwrite"
+	// PlanDelete means the object would be deleted.
+	PlanDelete = "delete"
+)
+
+// PlanActions contain all plan actions in the report order.
+var PlanActions = []string{PlanUpload, PlanOverwrite, PlanDelete}
+
+// PlanEntry is a change planned by dry-run.
+type PlanEntry struct {
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+}
+
+// PlanSummary is a total of planned changes with the same action.
+type PlanSummary struct {
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// PlanConfig is a configuration and the result collector of PlanObjects step.
+// You should always create new PlanConfig with NewPlanConfig constructor.
+type PlanConfig struct {
+	// DeleteSource record deletion of the source object for every planned object, like DeleteSourceObjects step does.
+	DeleteSource bool
+	// TargetIndex is used to check target objects. If nil, the object is checked with a request to target storage.
+	TargetIndex *TargetIndex
+	// TargetKey return the key of the object in target storage, if keys are changed by the steps skipped in dry-run.
+	// If nil, Object.Key is used.
+	TargetKey TargetKeyFunc
+	mu        sync.Mutex
+	entries   []PlanEntry
+}
+
+// NewPlanConfig return a new PlanConfig.
+func NewPlanConfig() *PlanConfig {
+	return &PlanConfig{}
+}
+
+// Add record planned change of the object with given key.
+func (cfg *PlanConfig) Add(action, key string, size int64) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.entries = append(cfg.entries, PlanEntry{
+		Action: action,
+		Key:    key,
+		Size:   size,
+	})
+}
+
+// Entries return all planned changes in the order they were recorded.
+func (cfg *PlanConfig) Entries() []PlanEntry {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	entries := make([]PlanEntry, len(cfg.entries))
+	copy(entries, cfg.entries)
+	return entries
+}
+
+// Summary return totals of planned changes by action.
+func (cfg *PlanConfig) Summary() map[string]PlanSummary {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	summary := make(map[string]PlanSummary, len(PlanActions))
+	for _, action := range PlanActions {
+		summary[action] = PlanSummary{}
+	}
+	for _, entry := range cfg.entries {
+		s := summary[entry.Action]
+		s.Objects++
+		s.Bytes += entry.Size
+		summary[entry.Action] = s
+	}
+	return summary
+}
+
+// PlanObjects read objects from input, record them to PlanConfig as uploads or overwrites
+// (and source deletions if PlanConfig.DeleteSource is set) and send objects to next pipeline steps.
+// Nothing is written to the target storage.
+// It replaces LoadObjectData and UploadObjectData steps in dry-run mode.
+// Object size is taken from Object.ContentLength, so object meta should be loaded if the source listing has no sizes.
+// Target objects are checked by PlanConfig.TargetKey, the planned upload is recorded with the target key.
+//
+// This step take configuration of *PlanConfig type, see pipeline.NewStep.
+var PlanObjects pipeline.TypedStepFn[*PlanConfig] = func(group *pipeline.Group, stepNum int, cfg *PlanConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		destObj := &storage.Object{Key: targetKey(cfg.TargetKey, obj)}
+		err := cfg.TargetIndex.CheckObjectExist(group.Target, destObj)
+		size := storage.ToValue(obj.ContentLength)
+		switch {
+		case err == nil:
+			cfg.Add(PlanOverwrite, *destObj.Key, size)
+		case storage.IsErrNotExist(err):
+			cfg.Add(PlanUpload, *destObj.Key, size)
+		default:
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+		if cfg.DeleteSource {
+			srcKey := obj.Key
+			if obj.OriginalKey != nil {
+				srcKey = obj.OriginalKey
+			}
+			cfg.Add(PlanDelete, *srcKey, size)
+		}
+		output <- obj
+	}
+}
@@ -0,0 +1,245 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Steps of the collection are registered in pipeline step registry with the names of its variables,
+// so they can be used in pipeline definitions, see pipeline.Definition.
+// Steps that require runtime objects (encryption keys, state database, verify and plan reports, bidirectional sync)
+// are not registered.
+func init() {
+	pipeline.RegisterStepFn("ListSourceStorage", ListSourceStorage)
+	pipeline.RegisterStepFn("LoadObjectMeta", LoadObjectMeta)
+	pipeline.RegisterStepFn("LoadObjectData", LoadObjectData)
+	pipeline.RegisterStepFn("LoadObjectACL", LoadObjectACL)
+	pipeline.RegisterStepFn("LoadObjectTags", LoadObjectTags)
+	pipeline.RegisterStepFn("LoadObjectLock", LoadObjectLock)
+	pipeline.RegisterStepFn("FilterObjectsDirs", FilterObjectsDirs)
+	pipeline.RegisterStepFn("FilterObjectsDirsNot", FilterObjectsDirsNot)
+	pipeline.RegisterStepFn("UploadObjectData", UploadObjectData)
+	pipeline.RegisterStepFn("Terminator", Terminator)
+
+	pipeline.RegisterTypedStepFn("FilterObjectsByExt", FilterObjectsByExt)
+	pipeline.RegisterTypedStepFn("FilterObjectsByExtNot", FilterObjectsByExtNot)
+	pipeline.RegisterTypedStepFn("FilterObjectsByCT", FilterObjectsByCT)
+	pipeline.RegisterTypedStepFn("FilterObjectsByCTNot", FilterObjectsByCTNot)
+	pipeline.RegisterTypedStepFn("FilterObjectsByMtimeAfter", FilterObjectsByMtimeAfter)
+	pipeline.RegisterTypedStepFn("FilterObjectsByMtimeBefore", FilterObjectsByMtimeBefore)
+	pipeline.RegisterTypedStepFn("ACLUpdater", ACLUpdater)
+	pipeline.RegisterTypedStepFn("StorageClassUpdater", StorageClassUpdater)
+	pipeline.RegisterTypedStepFn("CacheControlUpdater", CacheControlUpdater)
+	pipeline.RegisterTypedStepFn("ServerSideEncryptionUpdater", ServerSideEncryptionUpdater)
+	pipeline.RegisterTypedStepFn("TagsUpdater", TagsUpdater)
+	pipeline.RegisterTypedStepFn("PipelineRateLimit", PipelineRateLimit)
+	pipeline.RegisterTypedStepFn("CompressObjectData", CompressObjectData)
+	pipeline.RegisterTypedStepFn("DecompressObjectData", DecompressObjectData)
+	pipeline.RegisterTypedStepFn("ChecksumObjectData", ChecksumObjectData)
+
+	pipeline.RegisterStep("Logger", func(decode func(v interface{}) error) (pipeline.Step, error) {
+		return pipeline.NewStep("Logger", Logger, pipeline.Log), nil
+	})
+	pipeline.RegisterStep("FilterObjectsExist", newTargetIndexStepFactory("FilterObjectsExist", FilterObjectsExist))
+	pipeline.RegisterStep("FilterObjectsExistNot", newTargetIndexStepFactory("FilterObjectsExistNot", FilterObjectsExistNot))
+	pipeline.RegisterStep("FilterObjectsModified", newFilterObjectsModifiedStep)
+	pipeline.RegisterStep("ObjectLockUpdater", newObjectLockUpdaterStep)
+	pipeline.RegisterStep("RewriteObjectKey", newRewriteObjectKeyStep)
+	pipeline.RegisterStep("ListMergedSources", newListMergedSourcesStep)
+	pipeline.RegisterStep("UploadObjectDataFanOut", newUploadObjectDataFanOutStep)
+	pipeline.RegisterStep("DeleteSourceObjects", newDeleteSourceObjectsStep)
+}
+
+// TargetIndexDefinition is the pipeline definition configuration of target index.
+// The target is listed only if Index is set, see TargetIndex.
+// Each step creates its own index.
+type TargetIndexDefinition struct {
+	Index            bool
+	IndexThreshold   uint64
+	IndexMaxObjects  uint64
+	IndexSpillDir    string
+	IndexListWorkers int
+}
+
+func (def TargetIndexDefinition) targetIndex() *TargetIndex {
+	if !def.Index {
+		return nil
+	}
+	idx := NewTargetIndex(def.IndexThreshold, false)
+	idx.MaxObjects = def.IndexMaxObjects
+	idx.SpillDir = def.IndexSpillDir
+	idx.ListWorkers = def.IndexListWorkers
+	return idx
+}
+
+// newTargetIndexStepFactory return the factory of filters with TargetIndexDefinition configuration.
+func newTargetIndexStepFactory(name string, fn pipeline.TypedStepFn[ExistConfig]) pipeline.StepFactory {
+	return func(decode func(v interface{}) error) (pipeline.Step, error) {
+		def := TargetIndexDefinition{}
+		if err := decode(&def); err != nil {
+			return pipeline.Step{}, err
+		}
+		return pipeline.NewStep(name, fn, ExistConfig{TargetIndex: def.targetIndex()}), nil
+	}
+}
+
+// CompareDefinition is the pipeline definition configuration of FilterObjectsModified step.
+// Comparator is the name of comparator in Comparators, ETag comparator is used by default.
+// MtimeTolerance is a Go duration, like "2s".
+type CompareDefinition struct {
+	Comparator     string
+	MtimeTolerance string
+	NewerOnly      bool
+	TargetIndexDefinition
+}
+
+func newFilterObjectsModifiedStep(decode func(v interface{}) error) (pipeline.Step, error) {
+	def := CompareDefinition{Comparator: CompareETag}
+	if err := decode(&def); err != nil {
+		return pipeline.Step{}, err
+	}
+	comparator, ok := Comparators[def.Comparator]
+	if !ok {
+		return pipeline.Step{}, fmt.Errorf("unknown comparator: %s", def.Comparator)
+	}
+	cfg := CompareConfig{
+		Comparator:  comparator,
+		NewerOnly:   def.NewerOnly,
+		TargetIndex: def.targetIndex(),
+	}
+	if def.MtimeTolerance != "" {
+		var err error
+		if cfg.MtimeTolerance, err = time.ParseDuration(def.MtimeTolerance); err != nil {
+			return pipeline.Step{}, err
+		}
+	}
+	return pipeline.NewStep("FilterObjectsModified", FilterObjectsModified, cfg), nil
+}
+
+// ObjectLockDefinition is the pipeline definition configuration of ObjectLockUpdater step.
+// RetainPeriod is a Go duration, like "720h".
+type ObjectLockDefinition struct {
+	Mode            string
+	RetainPeriod    string
+	LegalHoldStatus string
+}
+
+func newObjectLockUpdaterStep(decode func(v interface{}) error) (pipeline.Step, error) {
+	def := ObjectLockDefinition{}
+	if err := decode(&def); err != nil {
+		return pipeline.Step{}, err
+	}
+	cfg := ObjectLockConfig{
+		Mode:            def.Mode,
+		LegalHoldStatus: def.LegalHoldStatus,
+	}
+	if def.RetainPeriod != "" {
+		var err error
+		if cfg.RetainPeriod, err = time.ParseDuration(def.RetainPeriod); err != nil {
+			return pipeline.Step{}, err
+		}
+	}
+	return pipeline.NewStep("ObjectLockUpdater", ObjectLockUpdater, cfg), nil
+}
+
+// KeyRewriteDefinition is the pipeline definition configuration of RewriteObjectKey step.
+// Rules are in REGEX=REPLACEMENT format, see ParseKeyRewriteRule.
+type KeyRewriteDefinition struct {
+	Rules              []string
+	SkipCollisionCheck bool
+}
+
+func newRewriteObjectKeyStep(decode func(v interface{}) error) (pipeline.Step, error) {
+	def := KeyRewriteDefinition{}
+	if err := decode(&def); err != nil {
+		return pipeline.Step{}, err
+	}
+	if len(def.Rules) == 0 {
+		return pipeline.Step{}, fmt.Errorf("key rewrite rules are not set")
+	}
+	rules := make([]KeyRewriteRule, 0, len(def.Rules))
+	for _, r := range def.Rules {
+		rule, err := ParseKeyRewriteRule(r)
+		if err != nil {
+			return pipeline.Step{}, fmt.Errorf("invalid key rewrite rule %q: %s", r, err)
+		}
+		rules = append(rules, rule)
+	}
+	return pipeline.NewStep("RewriteObjectKey", RewriteObjectKey, NewKeyRewriteConfig(rules, !def.SkipCollisionCheck)), nil
+}
+
+// MergeDefinition is the pipeline definition configuration of ListMergedSources step.
+// Sources are listed in the given order, see MergeConfig.Sources.
+type MergeDefinition struct {
+	Sources []string
+}
+
+func newListMergedSourcesStep(decode func(v interface{}) error) (pipeline.Step, error) {
+	def := MergeDefinition{}
+	if err := decode(&def); err != nil {
+		return pipeline.Step{}, err
+	}
+	return pipeline.NewStep("ListMergedSources", ListMergedSources, NewMergeConfig(def.Sources...)), nil
+}
+
+// FanOutDefinition is the pipeline definition configuration of UploadObjectDataFanOut step.
+// Empty Targets means all named targets of the group.
+type FanOutDefinition struct {
+	Targets []FanOutTargetDefinition
+}
+
+// FanOutTargetDefinition is the pipeline definition of FanOutTarget, see NewFanOutTarget.
+type FanOutTargetDefinition struct {
+	Name               string
+	ErrorMask          storage.ErrHandlingMask
+	RateLimitObjPerSec uint
+}
+
+func newUploadObjectDataFanOutStep(decode func(v interface{}) error) (pipeline.Step, error) {
+	def := FanOutDefinition{}
+	if err := decode(&def); err != nil {
+		return pipeline.Step{}, err
+	}
+	cfg := &FanOutConfig{}
+	for _, t := range def.Targets {
+		target, err := NewFanOutTarget(t.Name, t.ErrorMask, t.RateLimitObjPerSec)
+		if err != nil {
+			return pipeline.Step{}, err
+		}
+		cfg.Targets = append(cfg.Targets, target)
+	}
+	return pipeline.NewStep("UploadObjectDataFanOut", UploadObjectDataFanOut, cfg), nil
+}
+
+// MoveDefinition is the pipeline definition configuration of DeleteSourceObjects step.
+// Objects are deleted from its source storage, see pipeline.Group.DeleteSourceOf.
+type MoveDefinition struct {
+	Verify bool
+}
+
+// HasVerifiedMove check that the definition has DeleteSourceObjects step with Verify,
+// so the target storage should load checksums of uploaded objects.
+func HasVerifiedMove(def *pipeline.Definition) bool {
+	for _, stepDef := range def.Steps {
+		if stepDef.Step != "DeleteSourceObjects" || len(stepDef.Config) == 0 {
+			continue
+		}
+		moveDef := MoveDefinition{}
+		if err := json.Unmarshal(stepDef.Config, &moveDef); err == nil && moveDef.Verify {
+			return true
+		}
+	}
+	return false
+}
+
+func newDeleteSourceObjectsStep(decode func(v interface{}) error) (pipeline.Step, error) {
+	def := MoveDefinition{}
+	if err := decode(&def); err != nil {
+		return pipeline.Step{}, err
+	}
+	return pipeline.NewStep("DeleteSourceObjects", DeleteSourceObjects, MoveConfig{Verify: def.Verify}), nil
+}
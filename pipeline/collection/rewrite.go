@@ -0,0 +1,178 @@
+package collection
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// KeyRewriteFuncs contain functions available in key rewrite templates.
+var KeyRewriteFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    strings.ReplaceAll,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"base":       path.Base,
+	"dir":        path.Dir,
+	"ext":        path.Ext,
+}
+
+// KeyRewriteRule is a rule of RewriteObjectKey step.
+//
+// If Template is nil, all matches of Regexp in the key are replaced with Replacement,
+// Replacement can contain submatch references like $1.
+// If Template is set, the key is replaced with template output, Regexp is used only as a condition and can be nil.
+type KeyRewriteRule struct {
+	Regexp      *regexp.Regexp
+	Replacement string
+	Template    *template.Template
+}
+
+// KeyCollisionError raises when two source keys are rewritten to the same key.
+type KeyCollisionError struct {
+	Key      string
+	Original string
+	Other    string
+}
+
+func (e *KeyCollisionError) Error() string {
+	return fmt.Sprintf("keys %s and %s are rewritten to the same key %s", e.Other, e.Original, e.Key)
+}
+
+// KeyRewriteData is the data passed to key rewrite templates.
+type KeyRewriteData struct {
+	Key    string
+	Match  []string
+	Object *storage.Object
+}
+
+// KeyRewriteConfig is a configuration of RewriteObjectKey step.
+// You should always create new KeyRewriteConfig with NewKeyRewriteConfig constructor.
+type KeyRewriteConfig struct {
+	Rules           []KeyRewriteRule
+	CheckCollisions bool
+	mu              sync.Mutex
+	keys            map[string]string
+}
+
+// NewKeyRewriteConfig return a new KeyRewriteConfig.
+func NewKeyRewriteConfig(rules []KeyRewriteRule, checkCollisions bool) *KeyRewriteConfig {
+	return &KeyRewriteConfig{
+		Rules:           rules,
+		CheckCollisions: checkCollisions,
+		keys:            make(map[string]string),
+	}
+}
+
+// ParseKeyRewriteRule parse key rewrite rule in REGEX=REPLACEMENT format.
+// If REPLACEMENT contains "{{" it is parsed as a Go template.
+func ParseKeyRewriteRule(s string) (rule KeyRewriteRule, err error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return rule, fmt.Errorf("rule must be in \"REGEX=REPLACEMENT\" format")
+	}
+
+	if parts[0] != "" {
+		if rule.Regexp, err = regexp.Compile(parts[0]); err != nil {
+			return rule, err
+		}
+	}
+
+	if strings.Contains(parts[1], "{{") {
+		rule.Template, err = template.New(s).Funcs(KeyRewriteFuncs).Parse(parts[1])
+		return rule, err
+	}
+
+	if rule.Regexp == nil {
+		return rule, fmt.Errorf("REGEX is required for non template rules")
+	}
+	rule.Replacement = parts[1]
+	return rule, nil
+}
+
+// RewriteObjectKey read objects from input, rewrite its keys with ordered rules and send it to next pipeline steps.
+// All rules are applied sequentially, each rule gets the key produced by the previous one.
+// The original key is saved to Object.OriginalKey.
+//
+// This step should be placed after all steps which read objects from source storage.
+// If KeyRewriteConfig.CheckCollisions is set, KeyCollisionError is returned for the object which key is rewritten
+// to the key of already passed object. The collision is detected while objects pass the step, so the first object
+// may be already uploaded to target when the error is returned.
+//
+// This filter take configuration of *KeyRewriteConfig type, see pipeline.NewStep.
+var RewriteObjectKey pipeline.TypedStepFn[*KeyRewriteConfig] = func(group *pipeline.Group, stepNum int, cfg *KeyRewriteConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		key, err := cfg.rewrite(obj)
+		if err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+
+		if cfg.CheckCollisions {
+			if collision := cfg.checkCollision(*obj.Key, key); collision != "" {
+				errChan <- &pipeline.ObjectError{Object: obj, Err: &KeyCollisionError{Key: key, Original: *obj.Key, Other: collision}}
+				continue
+			}
+		}
+
+		if key != *obj.Key {
+			if obj.OriginalKey == nil {
+				obj.OriginalKey = obj.Key
+			}
+			obj.Key = &key
+		}
+		output <- obj
+	}
+}
+
+func (cfg *KeyRewriteConfig) rewrite(obj *storage.Object) (string, error) {
+	key := *obj.Key
+	for _, rule := range cfg.Rules {
+		var match []string
+		if rule.Regexp != nil {
+			if match = rule.Regexp.FindStringSubmatch(key); match == nil {
+				continue
+			}
+		}
+
+		if rule.Template == nil {
+			if rule.Regexp != nil {
+				key = rule.Regexp.ReplaceAllString(key, rule.Replacement)
+			}
+			continue
+		}
+
+		buf := &bytes.Buffer{}
+		if err := rule.Template.Execute(buf, KeyRewriteData{Key: key, Match: match, Object: obj}); err != nil {
+			return "", err
+		}
+		key = buf.String()
+	}
+
+	if key == "" {
+		return "", fmt.Errorf("key %s is rewritten to empty key", *obj.Key)
+	}
+	return key, nil
+}
+
+// checkCollision save the rewritten key and return the original key of another object with the same rewritten key.
+func (cfg *KeyRewriteConfig) checkCollision(originalKey, key string) string {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.keys == nil {
+		cfg.keys = make(map[string]string)
+	}
+	if prev, ok := cfg.keys[key]; ok && prev != originalKey {
+		return prev
+	}
+	cfg.keys[key] = originalKey
+	return ""
+}
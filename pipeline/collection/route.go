@@ -0,0 +1,28 @@
+package collection
+
+import (
+	"path/filepath"
+
+	"github.com/larrabee/s3sync/storage"
+)
+
+// RouteBySize return the pipeline.Route predicate matching objects with known ContentLength not less than minSize.
+// It can be used to send large objects to the streaming branch of pipeline.
+func RouteBySize(minSize int64) func(obj *storage.Object) bool {
+	return func(obj *storage.Object) bool {
+		return obj.ContentLength != nil && *obj.ContentLength >= minSize
+	}
+}
+
+// RouteByExt return the pipeline.Route predicate matching objects with given key extensions.
+func RouteByExt(exts ...string) func(obj *storage.Object) bool {
+	return func(obj *storage.Object) bool {
+		ext := filepath.Ext(*obj.Key)
+		for _, e := range exts {
+			if ext == e {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,101 @@
+package collection
+
+import (
+	"sync"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/state"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// StateConfig is a configuration of FilterObjectsUnchanged and SaveObjectsState steps.
+// You should always create new StateConfig with NewStateConfig constructor.
+type StateConfig struct {
+	DB      *state.DB[state.Entry]
+	mu      sync.Mutex
+	pending map[string]state.Entry
+}
+
+// NewStateConfig return a new StateConfig with given state database.
+func NewStateConfig(db *state.DB[state.Entry]) *StateConfig {
+	return &StateConfig{
+		DB:      db,
+		pending: make(map[string]state.Entry),
+	}
+}
+
+func (cfg *StateConfig) setPending(key string, entry state.Entry) {
+	cfg.mu.Lock()
+	cfg.pending[key] = entry
+	cfg.mu.Unlock()
+}
+
+func (cfg *StateConfig) takePending(key string) (state.Entry, bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	entry, ok := cfg.pending[key]
+	delete(cfg.pending, key)
+	return entry, ok
+}
+
+// stateKey return the key of object state, it is the original key if the key was rewritten.
+func stateKey(obj *storage.Object) string {
+	if obj.OriginalKey != nil {
+		return *obj.OriginalKey
+	}
+	return *obj.Key
+}
+
+// Save save the object state taken by FilterObjectsUnchanged to the state database.
+// Use it for objects that are in sync with target, like uploaded objects or objects that are not modified.
+func (cfg *StateConfig) Save(obj *storage.Object) error {
+	key := stateKey(obj)
+	if entry, found := cfg.takePending(key); found {
+		return cfg.DB.Put(key, entry)
+	}
+	return nil
+}
+
+// Discard forget the object state taken by FilterObjectsUnchanged without saving.
+// Use it for objects that failed to sync or were dropped by other steps.
+func (cfg *StateConfig) Discard(obj *storage.Object) {
+	cfg.takePending(stateKey(obj))
+}
+
+// FilterObjectsUnchanged accepts an input object and checks if it matches the filter
+// This filter compare source object state (ETag, size, mtime and version) with the state saved by SaveObjectsState
+// on the last successful upload and skip unchanged objects without requests to target storage.
+// Changes of target objects made by other tools are not detected.
+//
+// Object state is taken before any content transformation, so this filter should be placed
+// after object meta loading and before content loading steps.
+// The state of objects that are dropped by next steps should be saved or discarded, see StateConfig.Save and StateConfig.Discard.
+//
+// This filter take configuration of *StateConfig type, see pipeline.NewStep.
+var FilterObjectsUnchanged pipeline.TypedStepFn[*StateConfig] = func(group *pipeline.Group, stepNum int, cfg *StateConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		entry := state.NewEntry(obj)
+		if prev, found := cfg.DB.Get(*obj.Key); found && prev == entry {
+			storage.Log.Debugf("Object %s is not changed since the last sync, skipping", *obj.Key)
+			continue
+		}
+		cfg.setPending(*obj.Key, entry)
+		output <- obj
+	}
+}
+
+// SaveObjectsState read uploaded objects from input, save its state taken by FilterObjectsUnchanged to the state database
+// and send objects to next pipeline steps.
+// It should be placed after UploadObjectData step, which passes only successfully uploaded objects.
+// The state is saved by Object.OriginalKey if the key was rewritten.
+//
+// This step take configuration of *StateConfig type, see pipeline.NewStep.
+var SaveObjectsState pipeline.TypedStepFn[*StateConfig] = func(group *pipeline.Group, stepNum int, cfg *StateConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		if err := cfg.Save(obj); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+		output <- obj
+	}
+}
@@ -0,0 +1,148 @@
+package collection
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Statuses of mismatched objects in VerifyResult.
+const (
+	// VerifyMissing means the object exists in source storage, but is missing in target one.
+	VerifyMissing = "missing"
+	// VerifyExtra means the object exists in target storage, but is missing in source one.
+	VerifyExtra = "extra"
+	// VerifyDiffer means the object exists in both storages, but the comparator considers it modified.
+	VerifyDiffer = "differ"
+)
+
+// VerifyResult is a mismatched object found by VerifyObjects or VerifyExtraObjects steps.
+type VerifyResult struct {
+	Key        string  `json:"key"`
+	Status     string  `json:"status"`
+	SourceSize *int64  `json:"source_size,omitempty"`
+	TargetSize *int64  `json:"target_size,omitempty"`
+	SourceETag *string `json:"source_etag,omitempty"`
+	TargetETag *string `json:"target_etag,omitempty"`
+}
+
+// VerifyConfig is a configuration and the result collector of VerifyObjects and VerifyExtraObjects steps.
+// You should always create new VerifyConfig with NewVerifyConfig constructor.
+type VerifyConfig struct {
+	Compare CompareConfig
+	mu      sync.Mutex
+	checked uint64
+	results []VerifyResult
+}
+
+// NewVerifyConfig return a new VerifyConfig.
+func NewVerifyConfig(compare CompareConfig) *VerifyConfig {
+	if compare.Comparator == nil {
+		compare.Comparator = CompareObjectsByETag
+	}
+	return &VerifyConfig{
+		Compare: compare,
+	}
+}
+
+// Checked return the number of objects checked by VerifyObjects step.
+func (cfg *VerifyConfig) Checked() uint64 {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.checked
+}
+
+// Results return mismatched objects sorted by key.
+func (cfg *VerifyConfig) Results() []VerifyResult {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	results := make([]VerifyResult, len(cfg.results))
+	copy(results, cfg.results)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Key < results[j].Key
+	})
+	return results
+}
+
+// Count return the number of mismatched objects with given status.
+func (cfg *VerifyConfig) Count(status string) (count int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	for _, res := range cfg.results {
+		if res.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func (cfg *VerifyConfig) add(res VerifyResult) {
+	cfg.mu.Lock()
+	cfg.results = append(cfg.results, res)
+	cfg.mu.Unlock()
+}
+
+func (cfg *VerifyConfig) incChecked() {
+	cfg.mu.Lock()
+	cfg.checked++
+	cfg.mu.Unlock()
+}
+
+// VerifyObjects read objects from input, load its meta from target storage and compare objects with VerifyConfig.Compare.
+// Objects that are missing in target storage or differ are recorded to VerifyConfig.
+// All objects are sent to next pipeline steps.
+//
+// This step take configuration of *VerifyConfig type, see pipeline.NewStep.
+var VerifyObjects pipeline.TypedStepFn[*VerifyConfig] = func(group *pipeline.Group, stepNum int, cfg *VerifyConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		destObj := &storage.Object{
+			Key:       obj.Key,
+			VersionId: obj.VersionId,
+		}
+		err := group.Target.GetObjectMeta(destObj)
+		if err != nil && !storage.IsErrNotExist(err) {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+		cfg.incChecked()
+
+		if err != nil {
+			cfg.add(VerifyResult{Key: *obj.Key, Status: VerifyMissing, SourceSize: obj.ContentLength, SourceETag: obj.ETag})
+		} else if modified, err := cfg.Compare.Comparator(group, obj, destObj, cfg.Compare); err != nil {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		} else if modified {
+			cfg.add(VerifyResult{Key: *obj.Key, Status: VerifyDiffer,
+				SourceSize: obj.ContentLength, TargetSize: destObj.ContentLength,
+				SourceETag: obj.ETag, TargetETag: destObj.ETag,
+			})
+		}
+		output <- obj
+	}
+}
+
+// VerifyExtraObjects read objects from input and check that they exist in target storage.
+// Missing objects are recorded to VerifyConfig as VerifyExtra.
+// It should be used in the group with swapped storages: Source is the verified target storage and Target is the source one.
+// All objects are sent to next pipeline steps.
+//
+// This step take configuration of *VerifyConfig type, see pipeline.NewStep.
+var VerifyExtraObjects pipeline.TypedStepFn[*VerifyConfig] = func(group *pipeline.Group, stepNum int, cfg *VerifyConfig, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		srcObj := &storage.Object{
+			Key:       obj.Key,
+			VersionId: obj.VersionId,
+		}
+		err := group.Target.GetObjectMeta(srcObj)
+		if err != nil && !storage.IsErrNotExist(err) {
+			errChan <- &pipeline.ObjectError{Object: obj, Err: err}
+			continue
+		}
+		if err != nil {
+			cfg.add(VerifyResult{Key: *obj.Key, Status: VerifyExtra, TargetSize: obj.ContentLength, TargetETag: obj.ETag})
+		}
+		output <- obj
+	}
+}
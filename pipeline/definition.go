@@ -0,0 +1,237 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Definition is the pipeline definition: the list of registered steps with its settings and configurations.
+// Steps are added to the group in the definition order, see AddToGroup.
+// Fields of definition and step configurations are matched with Go field names case insensitive, like in encoding/json.
+type Definition struct {
+	Steps []StepDefinition
+}
+
+// StepDefinition is the definition of one pipeline step.
+type StepDefinition struct {
+	// Step is the name of registered step, see RegisterStep.
+	Step string
+	// Name is the name of the step in pipeline stats and logs. If empty, Step is used.
+	Name string
+	// Workers is the number of additional step workers.
+	Workers uint
+	// ChanSize is the size of step output buffer.
+	ChanSize uint
+	// ObjectTimeout is a Go duration, like "30s", see Step.ObjectTimeout.
+	ObjectTimeout string
+	// Config is the step configuration, its format depends on the step.
+	Config json.RawMessage
+	// Inputs of the step. If empty, the step read the default output of the previous step, see Step.Inputs.
+	Inputs []StepInputDefinition
+	// Routes split the step output to several named outputs, see Step.Routes.
+	Routes []RouteDefinition
+}
+
+// StepInputDefinition is the definition of step input, see StepInput.
+type StepInputDefinition struct {
+	// Step is the number of the definition step, starting from 0.
+	Step int
+	// Route is the name of the step output. Empty Route is the default output.
+	Route string
+}
+
+// RouteDefinition is the definition of step route, see Route.
+// Route match objects that satisfy all given conditions, at least one condition is required.
+type RouteDefinition struct {
+	Name string
+	// MinSize match objects with known ContentLength not less than MinSize.
+	MinSize int64
+	// Ext match objects with given key extensions, like ".log".
+	Ext []string
+}
+
+// match return the route predicate.
+func (def RouteDefinition) match() (func(obj *storage.Object) bool, error) {
+	if def.MinSize <= 0 && len(def.Ext) == 0 {
+		return nil, fmt.Errorf("route %q has no conditions", def.Name)
+	}
+	return func(obj *storage.Object) bool {
+		if def.MinSize > 0 && (obj.ContentLength == nil || *obj.ContentLength < def.MinSize) {
+			return false
+		}
+		if len(def.Ext) > 0 {
+			ext := filepath.Ext(*obj.Key)
+			for _, e := range def.Ext {
+				if ext == e {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	}, nil
+}
+
+// LoadDefinition read the pipeline definition from file.
+// Files with .json extension are parsed as JSON, other files as YAML.
+func LoadDefinition(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return ParseDefinitionJSON(data)
+	}
+	return ParseDefinitionYAML(data)
+}
+
+// ParseDefinitionJSON parse the pipeline definition in JSON format.
+// Unknown fields are not allowed in definition and step configurations.
+func ParseDefinitionJSON(data []byte) (*Definition, error) {
+	def := &Definition{}
+	if err := decodeStrict(data, def); err != nil {
+		return nil, err
+	}
+	if len(def.Steps) == 0 {
+		return nil, errors.New("pipeline definition has no steps")
+	}
+	for i, step := range def.Steps {
+		if step.Step == "" {
+			return nil, fmt.Errorf("pipeline definition step %d has no step name", i)
+		}
+	}
+	return def, nil
+}
+
+// ParseDefinitionYAML parse the pipeline definition in YAML format.
+// The YAML document is converted to JSON, so definition have the same fields in both formats.
+func ParseDefinitionYAML(data []byte) (*Definition, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc, err := yamlToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDefinitionJSON(jsonData)
+}
+
+// HasStep check that the definition has the step with given registered name.
+func (def *Definition) HasStep(step string) bool {
+	for _, stepDef := range def.Steps {
+		if stepDef.Step == step {
+			return true
+		}
+	}
+	return false
+}
+
+// AddToGroup create steps of the definition with registered step factories and add it to group.
+// Steps are added only if all of them are created successfully.
+// Input step numbers are relative to the definition, so the definition can be added to group with other steps.
+func (def *Definition) AddToGroup(group *Group) error {
+	first := len(group.steps)
+	steps := make([]Step, 0, len(def.Steps))
+	for i, stepDef := range def.Steps {
+		name := stepDef.Name
+		if name == "" {
+			name = stepDef.Step
+		}
+		factory, ok := LookupStep(stepDef.Step)
+		if !ok {
+			return &StepConfigurationError{StepName: name, StepNum: i, Err: &UnknownStepError{Step: stepDef.Step}}
+		}
+
+		config := stepDef.Config
+		step, err := factory(func(v interface{}) error {
+			if len(config) == 0 || bytes.Equal(config, []byte("null")) {
+				return nil
+			}
+			return decodeStrict(config, v)
+		})
+		if err != nil {
+			return &StepConfigurationError{StepName: name, StepNum: i, Err: err}
+		}
+		step.Name = name
+		step.AddWorkers = stepDef.Workers
+		step.ChanSize = stepDef.ChanSize
+		if stepDef.ObjectTimeout != "" {
+			if step.ObjectTimeout, err = time.ParseDuration(stepDef.ObjectTimeout); err != nil {
+				return &StepConfigurationError{StepName: name, StepNum: i, Err: err}
+			}
+		}
+		for _, input := range stepDef.Inputs {
+			if input.Step < 0 || input.Step >= i {
+				return &StepConfigurationError{StepName: name, StepNum: i, Err: fmt.Errorf("input step %d is not a previous step", input.Step)}
+			}
+			step.Inputs = append(step.Inputs, StepInput{Step: first + input.Step, Route: input.Route})
+		}
+		for _, routeDef := range stepDef.Routes {
+			match, err := routeDef.match()
+			if err != nil {
+				return &StepConfigurationError{StepName: name, StepNum: i, Err: err}
+			}
+			step.Routes = append(step.Routes, Route{Name: routeDef.Name, Match: match})
+		}
+		steps = append(steps, step)
+	}
+
+	for _, step := range steps {
+		group.AddPipeStep(step)
+	}
+	return nil
+}
+
+// decodeStrict unmarshal JSON data to v and fail on unknown fields.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// yamlToJSON convert YAML maps with interface{} keys to maps with string keys, so they can be marshaled to JSON.
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			strKey, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported YAML map key %v, only string keys are allowed", key)
+			}
+			jsonValue, err := yamlToJSON(value)
+			if err != nil {
+				return nil, err
+			}
+			res[strKey] = jsonValue
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, value := range v {
+			jsonValue, err := yamlToJSON(value)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = jsonValue
+		}
+		return res, nil
+	default:
+		return v, nil
+	}
+}
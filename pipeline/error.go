@@ -43,6 +43,28 @@ func (e *StepConfigurationError) Unwrap() error {
 	return e.Err
 }
 
+// StepPanicError raises when step function panics. It is sent as ObjectError with the object processed by the worker,
+// if the object is known. The worker is restarted with the next object.
+type StepPanicError struct {
+	StepName string
+	StepNum  int
+	Value    interface{}
+	Stack    []byte
+}
+
+func (e *StepPanicError) Error() string {
+	return fmt.Sprintf("pipeline step: %d (%s) panic: %v", e.StepNum, e.StepName, e.Value)
+}
+
+// UnknownStepError raises when pipeline definition use a step that is not registered, see RegisterStep.
+type UnknownStepError struct {
+	Step string
+}
+
+func (e *UnknownStepError) Error() string {
+	return fmt.Sprintf("step %s is not registered", e.Step)
+}
+
 // ObjectError contain a pointer to an Object that failed with error
 type ObjectError struct {
 	Object *storage.Object
@@ -50,6 +72,9 @@ type ObjectError struct {
 }
 
 func (e *ObjectError) Error() string {
+	if e.Object.OriginalKey != nil {
+		return fmt.Sprintf("object: %s (original key: %s) sync error: %s", *e.Object.Key, *e.Object.OriginalKey, e.Err)
+	}
 	return fmt.Sprintf("object: %s sync error: %s", *e.Object.Key, e.Err)
 }
 
@@ -2,8 +2,13 @@
 package pipeline
 
 import (
+	"errors"
+	"fmt"
 	"github.com/larrabee/s3sync/storage"
 	"github.com/sirupsen/logrus"
+	"reflect"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,13 +17,19 @@ import (
 var Log = logrus.New()
 
 // Group store a Source and Target storage's and pipeline configuration.
+// Besides the main Source and Target, group can store additional named sources and targets, see AddSource and AddTarget.
 type Group struct {
 	Source    storage.Storage
 	Target    storage.Storage
 	StartTime time.Time
+	timeout   time.Duration
+	sources   map[string]storage.Storage
+	deletes   map[string]storage.Storage
+	targets   map[string]storage.Storage
 	steps     []Step
 	errChan   chan error
 	errWg     *sync.WaitGroup
+	stepsWg   *sync.WaitGroup
 }
 
 // NewGroup return a new prepared Group.
@@ -27,6 +38,10 @@ func NewGroup() Group {
 	group := Group{
 		errChan: make(chan error),
 		errWg:   &sync.WaitGroup{},
+		stepsWg: &sync.WaitGroup{},
+		sources: make(map[string]storage.Storage),
+		deletes: make(map[string]storage.Storage),
+		targets: make(map[string]storage.Storage),
 		steps:   make([]Step, 0),
 	}
 	return group
@@ -42,43 +57,142 @@ func (group *Group) SetTarget(st storage.Storage) {
 	group.Target = st
 }
 
-// AddPipeStep add pipeline step to group.
+// SetObjectTimeout configure the default deadline of processing of one object by steps, see Step.ObjectTimeout.
+func (group *Group) SetObjectTimeout(timeout time.Duration) {
+	group.timeout = timeout
+}
+
+// AddSource add named source storage to group.
+// Objects listed from named sources should have Object.Origin set to the source name, see SourceOf.
+// Source with the same name is replaced.
+func (group *Group) AddSource(name string, st storage.Storage) {
+	group.sources[name] = st
+}
+
+// GetSource return named source storage.
+func (group *Group) GetSource(name string) (storage.Storage, bool) {
+	st, ok := group.sources[name]
+	return st, ok
+}
+
+// SourceNames return names of all named sources sorted.
+func (group *Group) SourceNames() []string {
+	names := make([]string, 0, len(group.sources))
+	for name := range group.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SourceOf return the source storage of the object: named source from Object.Origin or the main Source.
+func (group *Group) SourceOf(obj *storage.Object) storage.Storage {
+	if obj.Origin != "" {
+		if st, ok := group.sources[obj.Origin]; ok {
+			return st
+		}
+	}
+	return group.Source
+}
+
+// AddDeleteSource add storage used to delete objects of the source with given name, empty name is the main Source.
+// Source storages usually have cancellable context, so deletes can be interrupted on sync abort.
+// Pass the same storage without cancellable context, see DeleteSourceOf.
+func (group *Group) AddDeleteSource(name string, st storage.Storage) {
+	group.deletes[name] = st
+}
+
+// DeleteSourceOf return the storage used to delete the source object, see AddDeleteSource.
+// If it is not added, the source of the object is returned, see SourceOf.
+func (group *Group) DeleteSourceOf(obj *storage.Object) storage.Storage {
+	if st, ok := group.deletes[obj.Origin]; ok {
+		return st
+	}
+	return group.SourceOf(obj)
+}
+
+// AddTarget add named target storage to group.
+// Named targets are used by the steps writing to several targets, like UploadObjectDataFanOut.
+// Target with the same name is replaced.
+func (group *Group) AddTarget(name string, st storage.Storage) {
+	group.targets[name] = st
+}
+
+// GetTarget return named target storage.
+func (group *Group) GetTarget(name string) (storage.Storage, bool) {
+	st, ok := group.targets[name]
+	return st, ok
+}
+
+// TargetNames return names of all named targets sorted.
+func (group *Group) TargetNames() []string {
+	names := make([]string, 0, len(group.targets))
+	for name := range group.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddPipeStep add pipeline step to group and return its sequential number.
 // Steps will executed sequentially, in order of addition.
-func (group *Group) AddPipeStep(step Step) {
+// If Step.Inputs is empty, the step read the default output of the previous step.
+func (group *Group) AddPipeStep(step Step) int {
+	if len(step.Inputs) == 0 && len(group.steps) > 0 {
+		step.Inputs = []StepInput{{Step: len(group.steps) - 1}}
+	}
 	step.errChan = make(chan error)
 	step.workerWg = &sync.WaitGroup{}
+	step.stats = &StepStats{}
 	step.intOutChan = make(chan *storage.Object, step.ChanSize)
 	step.intInChan = make(chan *storage.Object)
-	step.outChan = make(chan *storage.Object)
+	step.outChans = make(map[string]chan *storage.Object)
 	group.steps = append(group.steps, step)
+	return len(group.steps) - 1
 }
 
 // GetStepsInfo return info about all pipeline steps.
 func (group *Group) GetStepsInfo() []StepInfo {
 	res := make([]StepInfo, len(group.steps))
 	for i := range group.steps {
-		res[i] = StepInfo{Stats: &group.steps[i].stats,
-			Name:   group.steps[i].Name,
-			Num:    i,
-			Config: group.steps[i].Config,
-		}
+		res[i] = group.GetStepInfo(i)
 	}
 	return res
 }
 
 // GetStepInfo return info about step with given sequential number.
 func (group *Group) GetStepInfo(stepNum int) StepInfo {
-	return StepInfo{Stats: &group.steps[stepNum].stats,
+	routes := make([]string, len(group.steps[stepNum].Routes))
+	for i, route := range group.steps[stepNum].Routes {
+		routes[i] = route.Name
+	}
+	return StepInfo{Stats: group.steps[stepNum].stats,
 		Name:   group.steps[stepNum].Name,
 		Num:    stepNum,
 		Config: group.steps[stepNum].Config,
+		Inputs: group.steps[stepNum].Inputs,
+		Routes: routes,
 	}
 }
 
 // Run start the pipeline execution.
 //
 // For result and error handling see ErrChan() function.
+// The group is validated before start, see Validate. If it is invalid, StepConfigurationError is sent to ErrChan
+// and steps are not started.
 func (group *Group) Run() {
+	if err := group.Validate(); err != nil {
+		group.StartTime = time.Now()
+		go func() {
+			group.errChan <- err
+			group.errChan <- nil
+			close(group.errChan)
+		}()
+		return
+	}
+	group.connectSteps()
+
+	group.stepsWg.Add(len(group.steps))
 	for i := 0; i < len(group.steps); i++ {
 
 		group.errWg.Add(1)
@@ -88,9 +202,77 @@ func (group *Group) Run() {
 		go copyInput(group, i)
 		go startWorkers(group, i)
 	}
+	go waitSteps(group)
 	group.StartTime = time.Now()
 }
 
+// Validate check configurations of steps created with NewStep and the steps graph.
+// Step can read only outputs of the previous steps, each output can be read by one step only.
+// All outputs of the step with routes must be read by the next steps, only the last step of the group can drop them.
+// It returns StepConfigurationError of the first invalid step.
+func (group *Group) Validate() error {
+	used := make(map[StepInput]bool)
+	for i := range group.steps {
+		step := &group.steps[i]
+		if step.Fn == nil {
+			return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: errors.New("step function is nil")}
+		}
+		if step.validate != nil {
+			if err := step.validate(step.Config); err != nil {
+				return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: err}
+			}
+		}
+
+		routes := make(map[string]bool)
+		for _, route := range step.Routes {
+			if route.Name == "" || route.Match == nil || routes[route.Name] {
+				return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: fmt.Errorf("route %q is invalid or not unique", route.Name)}
+			}
+			routes[route.Name] = true
+		}
+
+		for _, input := range step.Inputs {
+			if input.Step < 0 || input.Step >= i {
+				return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: fmt.Errorf("input step %d is not a previous step", input.Step)}
+			}
+			if !group.steps[input.Step].hasRoute(input.Route) {
+				return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: fmt.Errorf("step %d has no route %q", input.Step, input.Route)}
+			}
+			if used[input] {
+				return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: fmt.Errorf("route %q of step %d is already used as input", input.Route, input.Step)}
+			}
+			used[input] = true
+		}
+	}
+
+	for i := 0; i < len(group.steps)-1; i++ {
+		step := &group.steps[i]
+		if len(step.Routes) == 0 {
+			continue
+		}
+		if !used[StepInput{Step: i}] {
+			return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: errors.New("default output is not used as input")}
+		}
+		for _, route := range step.Routes {
+			if !used[StepInput{Step: i, Route: route.Name}] {
+				return &StepConfigurationError{StepName: step.Name, StepNum: i, Err: fmt.Errorf("route %q is not used as input", route.Name)}
+			}
+		}
+	}
+	return nil
+}
+
+// connectSteps create channels between step outputs and inputs.
+func (group *Group) connectSteps() {
+	for i := range group.steps {
+		for _, input := range group.steps[i].Inputs {
+			ch := make(chan *storage.Object)
+			group.steps[input.Step].outChans[input.Route] = ch
+			group.steps[i].inChans = append(group.steps[i].inChans, ch)
+		}
+	}
+}
+
 // ErrChan return a Group error chan.
 // All pipeline errors will be sent errors to this channel.
 //
@@ -113,45 +295,219 @@ func copyErr(group *Group, stepNum int) {
 }
 
 func copyOutput(group *Group, stepNum int) {
-	for obj := range group.steps[stepNum].intOutChan {
-		group.steps[stepNum].stats.Output.Add(1)
-		group.steps[stepNum].outChan <- obj
+	step := &group.steps[stepNum]
+	for obj := range step.intOutChan {
+		step.stats.Output.Add(1)
+		if ch, ok := step.outChans[step.routeOf(obj)]; ok {
+			ch <- obj
+		}
+	}
+	for _, ch := range step.outChans {
+		close(ch)
 	}
-	close(group.steps[stepNum].outChan)
 }
 
 func copyInput(group *Group, stepNum int) {
-	if stepNum > 0 {
-		for obj := range group.steps[stepNum-1].outChan {
-			group.steps[stepNum].stats.Input.Add(1)
-			group.steps[stepNum].intInChan <- obj
-		}
+	step := &group.steps[stepNum]
+	wg := sync.WaitGroup{}
+	for _, ch := range step.inChans {
+		wg.Add(1)
+		go func(ch chan *storage.Object) {
+			for obj := range ch {
+				step.stats.Input.Add(1)
+				step.intInChan <- obj
+			}
+			wg.Done()
+		}(ch)
 	}
-	close(group.steps[stepNum].intInChan)
+	wg.Wait()
+	close(step.intInChan)
 }
 
 func startWorkers(group *Group, stepNum int) {
-	for w := uint(0); w <= group.steps[stepNum].AddWorkers; w++ {
+	timeout := group.steps[stepNum].ObjectTimeout
+	if timeout == 0 {
+		timeout = group.timeout
+	}
+	workers := int(group.steps[stepNum].AddWorkers) + 1
+	var dispatcher *objectDispatcher
+	if len(group.steps[stepNum].inChans) > 0 {
+		dispatcher = newObjectDispatcher(group.steps[stepNum].intInChan, workers, timeout)
+	}
+	for w := 0; w < workers; w++ {
 		group.steps[stepNum].workerWg.Add(1)
-		go func(i int) {
-			if i == 0 {
-				group.steps[i].Fn(group, i, nil, group.steps[i].intOutChan, group.steps[i].errChan)
+		go func(i, w int) {
+			if dispatcher == nil {
+				runWorker(group, i, nil, nil, w)
 			} else {
-				group.steps[i].Fn(group, i, group.steps[i].intInChan, group.steps[i].intOutChan, group.steps[i].errChan)
+				for runWorker(group, i, dispatcher.inputs[w], dispatcher, w) {
+					Log.Debugf("Pipeline step: %s worker restarted after panic", group.steps[i].Name)
+				}
 			}
 			group.steps[i].workerWg.Done()
-		}(stepNum)
+		}(stepNum, w)
 	}
 
 	group.steps[stepNum].workerWg.Wait()
+	if dispatcher != nil {
+		close(dispatcher.done)
+	}
 	close(group.steps[stepNum].intOutChan)
 	close(group.steps[stepNum].errChan)
 	Log.Debugf("Pipeline step: %s finished", group.steps[stepNum].Name)
-	if stepNum+1 == len(group.steps) {
-		Log.Debugf("All pipeline steps finished")
-		group.errWg.Wait()
-		group.errChan <- nil
-		close(group.errChan)
-		Log.Debugf("Pipeline terminated")
+	group.stepsWg.Done()
+}
+
+// runWorker run the step function and recover its panic. It returns true if the function panicked.
+// The panic is sent as ObjectError with the object processed by the worker, if the dispatcher know it.
+func runWorker(group *Group, stepNum int, input <-chan *storage.Object, dispatcher *objectDispatcher, worker int) (panicked bool) {
+	step := &group.steps[stepNum]
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			err := &StepPanicError{StepName: step.Name, StepNum: stepNum, Value: r, Stack: debug.Stack()}
+			Log.Debugf("Pipeline step: %s panic: %v\n%s", step.Name, r, err.Stack)
+			if dispatcher != nil {
+				if obj := dispatcher.current(worker); obj != nil {
+					step.errChan <- &ObjectError{Object: obj, Err: err}
+					return
+				}
+			}
+			step.errChan <- err
+		}
+	}()
+	step.Fn(group, stepNum, input, step.intOutChan, step.errChan)
+	return false
+}
+
+// objectDispatcher pass objects of the step input to the step workers and keep the object processed by each worker.
+// It holds one object at a time and pass it to the first worker that receive it, so objects do not wait for busy workers
+// while others are idle. Object.Deadline is set right before the object is passed to the worker and it is renewed
+// while all workers are busy, so the time of waiting for a worker is not counted in the object timeout.
+type objectDispatcher struct {
+	inputs  []chan *storage.Object
+	curReq  chan currentRequest
+	done    chan struct{}
+	timeout time.Duration
+	cases   []reflect.SelectCase
+	renew   *time.Timer
+}
+
+// currentRequest is a request of the object processed by the worker.
+type currentRequest struct {
+	worker int
+	resp   chan *storage.Object
+}
+
+// deadlineRenewals is the number of Object.Deadline renewals per the object timeout while all workers are busy.
+const deadlineRenewals = 10
+
+func newObjectDispatcher(src <-chan *storage.Object, workers int, timeout time.Duration) *objectDispatcher {
+	d := &objectDispatcher{
+		inputs:  make([]chan *storage.Object, workers),
+		curReq:  make(chan currentRequest),
+		done:    make(chan struct{}),
+		timeout: timeout,
+	}
+	for i := range d.inputs {
+		d.inputs[i] = make(chan *storage.Object)
+		d.cases = append(d.cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(d.inputs[i])})
+	}
+	d.cases = append(d.cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(d.curReq)},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(d.done)},
+	)
+	if timeout/deadlineRenewals > 0 {
+		d.renew = time.NewTimer(timeout / deadlineRenewals)
+		d.renew.Stop()
+		d.cases = append(d.cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(d.renew.C)})
+	}
+	go d.run(src)
+	return d
+}
+
+// run read objects from src and pass it to the workers until done is closed.
+// The object is considered processed by the worker from its receiving to receiving of the next object,
+// passing of objects and requests of the current objects are handled in one goroutine, so they are consistent.
+func (d *objectDispatcher) run(src <-chan *storage.Object) {
+	cur := make([]*storage.Object, len(d.inputs))
+	for {
+		select {
+		case obj, ok := <-src:
+			if !ok {
+				for _, input := range d.inputs {
+					close(input)
+				}
+				src = nil
+				continue
+			}
+			if !d.pass(obj, cur) {
+				return
+			}
+		case req := <-d.curReq:
+			req.resp <- cur[req.worker]
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// pass send the object to the first worker ready to receive it and serve requests of the current objects meanwhile.
+// It returns false if done is closed.
+func (d *objectDispatcher) pass(obj *storage.Object, cur []*storage.Object) bool {
+	workers := len(d.inputs)
+	for i := 0; i < workers; i++ {
+		d.cases[i].Send = reflect.ValueOf(obj)
 	}
+	defer func() {
+		for i := 0; i < workers; i++ {
+			d.cases[i].Send = reflect.Value{}
+		}
+	}()
+	d.setDeadline(obj)
+	if d.renew != nil {
+		d.renew.Reset(d.timeout / deadlineRenewals)
+		defer d.renew.Stop()
+	}
+
+	for {
+		chosen, recv, _ := reflect.Select(d.cases)
+		switch {
+		case chosen < workers:
+			cur[chosen] = obj
+			return true
+		case chosen == workers:
+			req := recv.Interface().(currentRequest)
+			req.resp <- cur[req.worker]
+		case chosen == workers+1:
+			return false
+		default:
+			d.setDeadline(obj)
+			d.renew.Reset(d.timeout / deadlineRenewals)
+		}
+	}
+}
+
+func (d *objectDispatcher) setDeadline(obj *storage.Object) {
+	obj.Deadline = time.Time{}
+	if d.timeout > 0 {
+		obj.Deadline = time.Now().Add(d.timeout)
+	}
+}
+
+// current return the object processed by the worker.
+func (d *objectDispatcher) current(worker int) *storage.Object {
+	req := currentRequest{worker: worker, resp: make(chan *storage.Object)}
+	d.curReq <- req
+	return <-req.resp
+}
+
+// waitSteps wait for all steps and terminate the pipeline.
+func waitSteps(group *Group) {
+	group.stepsWg.Wait()
+	Log.Debugf("All pipeline steps finished")
+	group.errWg.Wait()
+	group.errChan <- nil
+	close(group.errChan)
+	Log.Debugf("Pipeline terminated")
 }
@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"sort"
+	"sync"
+)
+
+// StepFactory create a pipeline Step from the step configuration of pipeline definition.
+// decode unmarshal the configuration to the given pointer, it does nothing if the configuration is empty.
+type StepFactory func(decode func(v interface{}) error) (Step, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]StepFactory)
+)
+
+// RegisterStep make the step factory available by name in pipeline definitions, see Definition.
+// It panics if the name is empty or the step with the same name is already registered.
+func RegisterStep(name string, factory StepFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" || factory == nil {
+		panic("pipeline: RegisterStep with empty name or nil factory")
+	}
+	if _, dup := registry[name]; dup {
+		panic("pipeline: RegisterStep called twice for step " + name)
+	}
+	registry[name] = factory
+}
+
+// RegisterStepFn register the step without configuration, see RegisterStep.
+func RegisterStepFn(name string, fn StepFn) {
+	RegisterStep(name, func(decode func(v interface{}) error) (Step, error) {
+		return Step{Name: name, Fn: fn}, nil
+	})
+}
+
+// RegisterTypedStepFn register the step with configuration of type T, see RegisterStep.
+// The configuration of pipeline definition is decoded to T, zero T is used if it is empty.
+func RegisterTypedStepFn[T any](name string, fn TypedStepFn[T]) {
+	RegisterStep(name, func(decode func(v interface{}) error) (Step, error) {
+		var cfg T
+		if err := decode(&cfg); err != nil {
+			return Step{}, err
+		}
+		return NewStep(name, fn, cfg), nil
+	})
+}
+
+// LookupStep return the registered step factory by name.
+func LookupStep(name string) (StepFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// RegisteredSteps return names of all registered steps sorted.
+func RegisteredSteps() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,29 +1,118 @@
 package pipeline
 
 import (
+	"errors"
+	"fmt"
 	"github.com/larrabee/s3sync/storage"
+	"reflect"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // StepFn implement the type of pipeline Step function.
 type StepFn func(group *Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error)
 
+// TypedStepFn implement the type of pipeline Step function with typed configuration.
+// Steps with TypedStepFn should be created with NewStep.
+type TypedStepFn[T any] func(group *Group, stepNum int, cfg T, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error)
+
+// ConfigValidator is implemented by step configurations that can be validated before pipeline start.
+type ConfigValidator interface {
+	Validate() error
+}
+
 // Step contain configuration of pipeline step and it's internal structure.
-// Be careful with Config interface! Check of its type should implemented in StepFn.
-// If typing fails, you get a StepConfigurationError in runtime.
+// Panics of step function are recovered and sent as StepPanicError, see StepPanicError.
+// Steps with configuration should be created with NewStep, so the Config type is checked and validated by Group.Validate
+// before pipeline start. Steps without configuration can be created as Step{} with StepFn.
+//
+// ObjectTimeout is the deadline of processing of one object by the step, it is set to Object.Deadline
+// when the worker receive the object. Storage calls of the step fail when the deadline is exceeded.
+// Content streams opened by the step are read by the next steps, so they fail only if one read of the stream
+// takes longer than the step timeout, see storage.StreamContext.
+// If zero, the group default is used, see Group.SetObjectTimeout.
+//
+// By default step read output of the previous step. Inputs allow to read outputs of any previous steps,
+// so pipeline can have branches and merge them back. Routes split the step output to several named outputs.
 type Step struct {
-	Name       string
-	Fn         StepFn
-	AddWorkers uint
-	Config     interface{}
-	ChanSize   uint
-	outChan    chan *storage.Object
-	intOutChan chan *storage.Object
-	intInChan  chan *storage.Object
-	errChan    chan error
-	workerWg   *sync.WaitGroup
-	stats      StepStats
+	Name          string
+	Fn            StepFn
+	AddWorkers    uint
+	Config        interface{}
+	ChanSize      uint
+	ObjectTimeout time.Duration
+	Inputs        []StepInput
+	Routes        []Route
+	inChans       []chan *storage.Object
+	outChans      map[string]chan *storage.Object
+	intOutChan    chan *storage.Object
+	intInChan     chan *storage.Object
+	errChan       chan error
+	workerWg      *sync.WaitGroup
+	stats         *StepStats
+	validate      func(config interface{}) error
+}
+
+// NewStep return a new Step with typed function and configuration.
+// If the configuration implements ConfigValidator, it is validated by Group.Validate.
+func NewStep[T any](name string, fn TypedStepFn[T], cfg T) Step {
+	return Step{
+		Name:   name,
+		Config: cfg,
+		Fn: func(group *Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+			// Config type is checked by Group.Validate before the pipeline start.
+			stepCfg, _ := group.steps[stepNum].Config.(T)
+			fn(group, stepNum, stepCfg, input, output, errChan)
+		},
+		validate: validateConfig[T],
+	}
+}
+
+// WithWorkers set number of additional step workers.
+func (step Step) WithWorkers(n uint) Step {
+	step.AddWorkers = n
+	return step
+}
+
+// WithChanSize set size of step output buffer.
+func (step Step) WithChanSize(n uint) Step {
+	step.ChanSize = n
+	return step
+}
+
+// WithObjectTimeout set the deadline of processing of one object by the step.
+func (step Step) WithObjectTimeout(timeout time.Duration) Step {
+	step.ObjectTimeout = timeout
+	return step
+}
+
+// WithInputs set step inputs, see StepInput.
+func (step Step) WithInputs(inputs ...StepInput) Step {
+	step.Inputs = inputs
+	return step
+}
+
+// WithRoutes set step routes, see Route.
+func (step Step) WithRoutes(routes ...Route) Step {
+	step.Routes = routes
+	return step
+}
+
+// validateConfig check that the config has type T and is valid.
+// Nil pointer is valid only if the config implements ConfigValidator and its Validate accepts nil.
+func validateConfig[T any](config interface{}) error {
+	cfg, ok := config.(T)
+	if !ok {
+		return fmt.Errorf("invalid configuration type %T, expected %T", config, *new(T))
+	}
+	if validator, ok := any(cfg).(ConfigValidator); ok {
+		return validator.Validate()
+	}
+	if v := reflect.ValueOf(config); v.Kind() == reflect.Pointer && v.IsNil() {
+		return errors.New("configuration is nil")
+	}
+	return nil
 }
 
 // StepStats to keep basic step statistics.
@@ -33,10 +122,52 @@ type StepStats struct {
 	Error  atomic.Uint64
 }
 
+// StepInput is an input of pipeline step: the output of the step with number Step.
+// Route is the name of the step output, see Route. Empty Route is the default output.
+type StepInput struct {
+	Step  int
+	Route string
+}
+
+// Route is a named output of pipeline step.
+// Output objects are sent to the first route they Match, objects not matched by any route are sent to the default output.
+// All outputs of the step with routes, including the default output, must be used as input of the next steps,
+// only the last step of the group can drop objects sent to its outputs, see Group.Validate.
+type Route struct {
+	Name  string
+	Match func(obj *storage.Object) bool
+}
+
 // StepInfo is used to represent step information, statistic and the step configuration interface.
+// Inputs and Routes describe the pipeline graph: inputs of the step and names of its routes.
 type StepInfo struct {
 	Stats  *StepStats
 	Name   string
 	Num    int
 	Config interface{}
+	Inputs []StepInput
+	Routes []string
+}
+
+// hasRoute check that the step has the route with given name. Empty name is the default output.
+func (step *Step) hasRoute(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, route := range step.Routes {
+		if route.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// routeOf return the name of the route matched by the object or empty name of the default output.
+func (step *Step) routeOf(obj *storage.Object) string {
+	for _, route := range step.Routes {
+		if route.Match(obj) {
+			return route.Name
+		}
+	}
+	return ""
 }
@@ -0,0 +1,33 @@
+package state
+
+import (
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Entry is the state of the object at the last sync.
+type Entry struct {
+	ETag      string `json:"etag,omitempty"`
+	Size      int64  `json:"size"`
+	Mtime     int64  `json:"mtime,omitempty"`
+	VersionId string `json:"version_id,omitempty"`
+}
+
+// NewEntry return the state entry of the object with loaded metadata.
+// Original mtime from object metadata (storage.MetaMtime) is preferred over object Mtime.
+func NewEntry(obj *storage.Object) Entry {
+	entry := Entry{
+		ETag:      storage.ToValue(obj.ETag),
+		Size:      storage.ToValue(obj.ContentLength),
+		VersionId: storage.ToValue(obj.VersionId),
+	}
+	if v, ok := storage.GetMetadata(obj, storage.MetaMtime); ok {
+		if t, err := storage.ParseUnixTime(v); err == nil {
+			entry.Mtime = t.UnixNano()
+			return entry
+		}
+	}
+	if obj.Mtime != nil {
+		entry.Mtime = obj.Mtime.UnixNano()
+	}
+	return entry
+}
@@ -0,0 +1,179 @@
+// Package state provides the local on-disk database of synced objects state.
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// batchSize is the number of changes that are buffered in memory before they are written to the file.
+const batchSize = 1000
+
+var bucketName = []byte("state")
+
+// change is a buffered change of the key.
+type change[V any] struct {
+	value   V
+	deleted bool
+}
+
+// DB is the key-value database stored in a bbolt file, values are encoded in JSON.
+// Changes are buffered in memory and written to the file in batches of batchSize changes, on Flush and on Close,
+// so the last changes can be lost on crash. Only buffered changes are kept in memory.
+//
+// DB is safe for concurrent use.
+type DB[V any] struct {
+	db      *bolt.DB
+	mu      sync.RWMutex
+	changes map[string]change[V]
+}
+
+// Open load the database from the file with given path or create a new one if the file does not exist.
+// It fails if the database is opened by another process.
+func Open[V any](path string) (*DB[V], error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB[V]{
+		db:      db,
+		changes: make(map[string]change[V]),
+	}, nil
+}
+
+// Get return the value of the key.
+func (db *DB[V]) Get(key string) (V, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var value V
+	if c, ok := db.changes[key]; ok {
+		return c.value, !c.deleted
+	}
+
+	found := false
+	_ = db.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketName).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &value) == nil
+		return nil
+	})
+	return value, found
+}
+
+// Put set the value of the key.
+func (db *DB[V]) Put(key string, value V) error {
+	return db.set(key, change[V]{value: value})
+}
+
+// Delete remove the key.
+func (db *DB[V]) Delete(key string) error {
+	return db.set(key, change[V]{deleted: true})
+}
+
+func (db *DB[V]) set(key string, c change[V]) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.changes[key] = c
+	if len(db.changes) < batchSize {
+		return nil
+	}
+	return db.flush()
+}
+
+// flush write buffered changes to the file in one transaction.
+func (db *DB[V]) flush() error {
+	if len(db.changes) == 0 {
+		return nil
+	}
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		for key, c := range db.changes {
+			if c.deleted {
+				if err := bucket.Delete([]byte(key)); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := json.Marshal(c.value)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	db.changes = make(map[string]change[V])
+	return nil
+}
+
+// Keys return all keys sorted.
+func (db *DB[V]) Keys() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	keys := make([]string, 0, len(db.changes))
+	_ = db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(k, v []byte) error {
+			// Buffered changes override stored keys.
+			if _, ok := db.changes[string(k)]; !ok {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	for key, c := range db.changes {
+		if !c.deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len return the number of keys.
+func (db *DB[V]) Len() int {
+	return len(db.Keys())
+}
+
+// Flush write buffered changes to the file.
+func (db *DB[V]) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.flush()
+}
+
+// Close write buffered changes to the file and close it.
+func (db *DB[V]) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.flush(); err != nil {
+		db.db.Close()
+		return err
+	}
+	return db.db.Close()
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// Supported content checksum algorithms. The values are the same as S3 ChecksumAlgorithm values.
+const (
+	ChecksumCRC32C = "CRC32C"
+	ChecksumSHA256 = "SHA256"
+)
+
+// Metadata keys used to record content checksums by storages without native checksums support.
+const (
+	MetaChecksumCRC32C = "S3sync-Checksum-Crc32c"
+	MetaChecksumSHA256 = "S3sync-Checksum-Sha256"
+)
+
+// ChecksumAlgorithms contain all supported checksum algorithms.
+var ChecksumAlgorithms = []string{ChecksumCRC32C, ChecksumSHA256}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumMismatchError raises when object content checksum does not match the expected one.
+type ChecksumMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// NewChecksumHash return a new hash.Hash of given checksum algorithm.
+func NewChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+}
+
+// EncodeChecksum encode checksum in S3 format (base64).
+func EncodeChecksum(sum []byte) string {
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// GetChecksum return object checksum of given algorithm.
+func GetChecksum(obj *Object, algorithm string) *string {
+	switch algorithm {
+	case ChecksumCRC32C:
+		return obj.ChecksumCRC32C
+	case ChecksumSHA256:
+		return obj.ChecksumSHA256
+	default:
+		return nil
+	}
+}
+
+// SetChecksum set object checksum of given algorithm.
+func SetChecksum(obj *Object, algorithm string, value *string) {
+	switch algorithm {
+	case ChecksumCRC32C:
+		obj.ChecksumCRC32C = value
+	case ChecksumSHA256:
+		obj.ChecksumSHA256 = value
+	}
+}
+
+// ClearChecksums remove all object checksums. Use it after modification of object content.
+func ClearChecksums(obj *Object) {
+	obj.ChecksumCRC32C = nil
+	obj.ChecksumSHA256 = nil
+}
+
+// FullObjectChecksum return checksum if it is a checksum of full object content.
+// Checksums of multipart uploads have "-<parts count>" suffix and can't be verified, nil is returned for them.
+func FullObjectChecksum(s *string) *string {
+	if s == nil || *s == "" || strings.Contains(*s, "-") {
+		return nil
+	}
+	return s
+}
+
+// ChecksumsToMetadata save object checksums to metadata.
+func ChecksumsToMetadata(obj *Object) {
+	if obj.ChecksumCRC32C != nil {
+		SetMetadata(obj, MetaChecksumCRC32C, *obj.ChecksumCRC32C)
+	}
+	if obj.ChecksumSHA256 != nil {
+		SetMetadata(obj, MetaChecksumSHA256, *obj.ChecksumSHA256)
+	}
+}
+
+// ChecksumsFromMetadata load object checksums from metadata and remove them from metadata.
+func ChecksumsFromMetadata(obj *Object) {
+	if v, ok := GetMetadata(obj, MetaChecksumCRC32C); ok {
+		obj.ChecksumCRC32C = &v
+		DeleteMetadata(obj, MetaChecksumCRC32C)
+	}
+	if v, ok := GetMetadata(obj, MetaChecksumSHA256); ok {
+		obj.ChecksumSHA256 = &v
+		DeleteMetadata(obj, MetaChecksumSHA256)
+	}
+}
@@ -69,3 +69,24 @@ func IsAwsContextCanceled(err error) bool {
 
 	return false
 }
+
+// IsErrChecksumMismatch check that error is caused by content checksum mismatch,
+// detected by s3sync or reported by S3 server.
+func IsErrChecksumMismatch(err error) bool {
+	var cErr *ChecksumMismatchError
+	if errors.As(err, &cErr) {
+		return true
+	}
+
+	var aErr awserr.Error
+	if errors.As(err, &aErr) {
+		if aErr.Code() == "BadDigest" || aErr.Code() == "XAmzContentChecksumMismatch" {
+			return true
+		}
+		if errors.As(aErr.OrigErr(), &cErr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,128 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Metadata keys used to store POSIX file attributes.
+// The keys and values format are compatible with s3fs-fuse: times are unix timestamps
+// with optional fractional part, mode is a decimal st_mode value.
+// Note that rclone use the same keys, but stores mode in octal, so rclone mode values are not compatible.
+const (
+	MetaMtime = storage.MetaMtime
+	MetaAtime = "atime"
+	MetaMode  = "mode"
+	MetaUid   = "uid"
+	MetaGid   = "gid"
+)
+
+const unixModeRegular = 0100000
+
+// setPosixAttrsMeta save file attributes to object metadata.
+func (st *FSStorage) setPosixAttrsMeta(obj *storage.Object, fi os.FileInfo) {
+	storage.SetMetadata(obj, MetaMtime, storage.FormatUnixTime(fi.ModTime()))
+	storage.SetMetadata(obj, MetaMode, strconv.FormatUint(uint64(toUnixMode(fi.Mode())), 10))
+	if uid, gid, ok := fileOwner(fi); ok {
+		storage.SetMetadata(obj, MetaUid, strconv.Itoa(uid))
+		storage.SetMetadata(obj, MetaGid, strconv.Itoa(gid))
+	}
+	if st.posixAtime {
+		if atime, ok := fileAtime(fi); ok {
+			storage.SetMetadata(obj, MetaAtime, storage.FormatUnixTime(atime))
+		}
+	}
+}
+
+// restorePosixAttrs set file attributes from object metadata.
+// If mtime is not stored in metadata, the object Mtime is used.
+// Ownership is restored only if it is permitted for current user.
+func (st *FSStorage) restorePosixAttrs(f *os.File, obj *storage.Object) error {
+	uid, gid := -1, -1
+	if v, ok := storage.GetMetadata(obj, MetaUid); ok {
+		if id, err := strconv.Atoi(v); err == nil {
+			uid = id
+		}
+	}
+	if v, ok := storage.GetMetadata(obj, MetaGid); ok {
+		if id, err := strconv.Atoi(v); err == nil {
+			gid = id
+		}
+	}
+	if uid != -1 || gid != -1 {
+		if err := f.Chown(uid, gid); err != nil {
+			if !errors.Is(err, os.ErrPermission) {
+				return err
+			}
+			storage.Log.Debugf("Not permitted to change owner of object %s, skipping", *obj.Key)
+		}
+	}
+
+	// Mode is set after owner, because chown clears setuid and setgid bits.
+	if v, ok := storage.GetMetadata(obj, MetaMode); ok {
+		if mode, err := strconv.ParseUint(v, 10, 32); err == nil {
+			if err := f.Chmod(fromUnixMode(uint32(mode))); err != nil {
+				return err
+			}
+		} else {
+			storage.Log.Debugf("Object %s has invalid mode metadata: %s", *obj.Key, v)
+		}
+	}
+
+	mtime := restoredMtime(obj)
+	if mtime.IsZero() {
+		return nil
+	}
+	atime := mtime
+	if v, ok := storage.GetMetadata(obj, MetaAtime); ok {
+		if t, err := storage.ParseUnixTime(v); err == nil {
+			atime = t
+		}
+	}
+	return os.Chtimes(f.Name(), atime, mtime)
+}
+
+// restoredMtime return the file mtime that restorePosixAttrs set: mtime from metadata or the object Mtime.
+func restoredMtime(obj *storage.Object) time.Time {
+	mtime := storage.ToValue(obj.Mtime)
+	if v, ok := storage.GetMetadata(obj, MetaMtime); ok {
+		if t, err := storage.ParseUnixTime(v); err == nil {
+			mtime = t
+		}
+	}
+	return mtime
+}
+
+// toUnixMode convert Go file mode to unix st_mode of regular file.
+func toUnixMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm()) | unixModeRegular
+	if m&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// fromUnixMode convert unix st_mode to Go file permissions.
+func fromUnixMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
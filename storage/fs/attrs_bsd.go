@@ -0,0 +1,31 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid), true
+	}
+	return 0, 0, false
+}
+
+func fileAtime(fi os.FileInfo) (time.Time, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec)), true
+	}
+	return time.Time{}, false
+}
+
+func fileInode(fi os.FileInfo) (uint64, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino), true
+	}
+	return 0, false
+}
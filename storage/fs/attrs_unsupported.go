@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package fs
+
+import (
+	"os"
+	"time"
+)
+
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func fileAtime(fi os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func fileInode(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,39 @@
+package fs
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/larrabee/s3sync/storage"
+)
+
+// MetaChecksumStamp is the metadata key with file size and mtime at the moment when checksums were recorded.
+// Recorded checksums are valid only while the file has the same size and mtime.
+const MetaChecksumStamp = "S3sync-Checksum-Stamp"
+
+// checksumStamp return the value of MetaChecksumStamp metadata.
+func checksumStamp(size int64, mtime time.Time) string {
+	return strconv.FormatInt(size, 10) + ":" + strconv.FormatInt(mtime.UnixNano(), 10)
+}
+
+// finalMtime return the file mtime after PutObject, fileInfo is the info of written file.
+func (st *FSStorage) finalMtime(obj *storage.Object, fileInfo os.FileInfo) time.Time {
+	if st.posixAttrs {
+		if mtime := restoredMtime(obj); !mtime.IsZero() {
+			return mtime
+		}
+	}
+	return fileInfo.ModTime()
+}
+
+// loadChecksums set object checksums from metadata if checksums are enabled and still valid.
+// Checksums metadata is always removed from the object user metadata.
+func (st *FSStorage) loadChecksums(obj *storage.Object, fileInfo os.FileInfo) {
+	stamp, ok := storage.GetMetadata(obj, MetaChecksumStamp)
+	storage.DeleteMetadata(obj, MetaChecksumStamp)
+	storage.ChecksumsFromMetadata(obj)
+	if !st.checksums || !ok || stamp != checksumStamp(fileInfo.Size(), fileInfo.ModTime()) {
+		storage.ClearChecksums(obj)
+	}
+}
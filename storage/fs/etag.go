@@ -0,0 +1,116 @@
+package fs
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/larrabee/s3sync/storage"
+)
+
+// etagCacheEntry is a computed ETag of the file, valid while file size, mtime and inode are not changed.
+type etagCacheEntry struct {
+	Size     int64  `json:"size"`
+	Mtime    int64  `json:"mtime"`
+	Inode    uint64 `json:"inode"`
+	PartSize int64  `json:"part_size"`
+	ETag     string `json:"etag"`
+}
+
+// loadETag set object ETag computed from the file content.
+// Computed ETag is cached in the meta store.
+func (st *FSStorage) loadETag(obj *storage.Object, f *os.File, path string, fileInfo os.FileInfo) error {
+	entry := st.newETagCacheEntry(fileInfo)
+	if etag, ok := st.cachedETag(f, path, entry); ok {
+		obj.ETag = &etag
+		return nil
+	}
+
+	etag, err := computeETag(f, entry.Size, entry.PartSize)
+	if err != nil {
+		return err
+	}
+	obj.ETag = &etag
+	st.cacheETag(f, path, entry, etag)
+	return nil
+}
+
+// newETagCacheEntry return the ETag cache entry of file without ETag.
+func (st *FSStorage) newETagCacheEntry(fileInfo os.FileInfo) etagCacheEntry {
+	entry := etagCacheEntry{
+		Size:     fileInfo.Size(),
+		Mtime:    fileInfo.ModTime().UnixNano(),
+		PartSize: st.etagPartSize,
+	}
+	entry.Inode, _ = fileInode(fileInfo)
+	return entry
+}
+
+// cachedETag return ETag from the meta store if the file is not changed since it was cached.
+func (st *FSStorage) cachedETag(f *os.File, path string, entry etagCacheEntry) (string, bool) {
+	if st.meta == nil {
+		return "", false
+	}
+	data, err := st.meta.Get(f, path, MetaAttrETag)
+	if err != nil {
+		return "", false
+	}
+	cached := etagCacheEntry{}
+	if err := json.Unmarshal(data, &cached); err != nil || cached.ETag == "" {
+		return "", false
+	}
+	etag := cached.ETag
+	cached.ETag = ""
+	if cached != entry {
+		return "", false
+	}
+	return etag, true
+}
+
+// cacheETag save computed ETag to the meta store. Errors are only logged, ETag will be computed again next time.
+func (st *FSStorage) cacheETag(f *os.File, path string, entry etagCacheEntry, etag string) {
+	if st.meta == nil {
+		return
+	}
+	entry.ETag = etag
+	data, err := json.Marshal(entry)
+	if err != nil {
+		storage.Log.Debugf("Failed to cache ETag of file %s, err: %s", path, err)
+		return
+	}
+	if err := st.meta.Set(f, path, MetaAttrETag, data); err != nil {
+		storage.Log.Debugf("Failed to cache ETag of file %s, err: %s", path, err)
+	}
+}
+
+// computeETag return S3 compatible ETag of the content.
+// If partSize is positive and the content is larger, ETag of the multipart upload
+// ("md5 of parts md5s"-"parts count") is returned, otherwise it is a content MD5.
+func computeETag(r io.Reader, size, partSize int64) (string, error) {
+	if partSize <= 0 || size <= partSize {
+		h := md5.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("\"%x\"", h.Sum(nil)), nil
+	}
+
+	sums := md5.New()
+	parts := 0
+	for {
+		h := md5.New()
+		n, err := io.CopyN(h, r, partSize)
+		if n > 0 {
+			sums.Write(h.Sum(nil))
+			parts++
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("\"%x-%d\"", sums.Sum(nil), parts), nil
+}
@@ -5,17 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/karrick/godirwalk"
 	"github.com/larrabee/ratelimit"
 	"github.com/larrabee/s3sync/storage"
-	"github.com/pkg/xattr"
 )
 
 const tempFileSuffixLen = 8
@@ -26,11 +27,18 @@ type FSStorage struct {
 	filePerm      os.FileMode
 	dirPerm       os.FileMode
 	bufSize       int
-	xattr         bool
+	meta          MetaStore
 	ctx           context.Context
 	rlBucket      ratelimit.Bucket
 	listErrorMask storage.ErrHandlingMask
 	atomicWrite   bool
+	keyEncoding   bool
+	posixAttrs    bool
+	posixAtime    bool
+	symlinks      string
+	etag          bool
+	etagPartSize  int64
+	checksums     bool
 }
 
 // NewFSStorage return new configured FS storage.
@@ -41,14 +49,17 @@ func NewFSStorage(dir string, filePerm, dirPerm os.FileMode, bufSize int, extend
 		dir:           filepath.Clean(dir) + "/",
 		filePerm:      filePerm,
 		dirPerm:       dirPerm,
-		xattr:         extendedMeta && isXattrSupported(),
 		rlBucket:      ratelimit.NewFakeBucket(),
 		listErrorMask: listErrorMode,
 		atomicWrite:   atomicWrite,
+		symlinks:      SymlinksFollow,
+		ctx:           context.TODO(),
 	}
 
 	if extendedMeta && !isXattrSupported() {
 		storage.Log.Warnf("Xattr switch enabled, but your system does not support xattr, it will be disabled.")
+	} else if extendedMeta {
+		st.meta = XattrMetaStore{}
 	}
 
 	if bufSize < godirwalk.MinimumScratchBufferSize {
@@ -74,30 +85,130 @@ func (st *FSStorage) WithRateLimit(limit int) error {
 	return nil
 }
 
+// WithKeyEncoding enable reversible encoding of object keys to FS-safe paths.
+// See encodeKey for encoding details.
+func (st *FSStorage) WithKeyEncoding(enable bool) {
+	st.keyEncoding = enable
+}
+
+// WithPosixAttrs enable preserving of file mtime, mode and ownership in object metadata.
+// On read, attributes are stored to metadata, on write they are restored from it.
+// If atime is set, file access time is preserved too.
+func (st *FSStorage) WithPosixAttrs(enable, atime bool) {
+	st.posixAttrs = enable
+	st.posixAtime = atime
+}
+
+// WithMetaStore set the store of objects metadata, it replaces xattr store enabled by constructor.
+// Nil value disables metadata storing.
+func (st *FSStorage) WithMetaStore(meta MetaStore) {
+	st.meta = meta
+}
+
+// WithETag enable computing of S3 compatible ETag for files without stored ETag.
+// If partSize is positive, files larger than partSize get the ETag of multipart upload with such part size,
+// otherwise ETag is the content MD5.
+// Computed ETags are cached in the meta store until file size, mtime or inode are changed.
+func (st *FSStorage) WithETag(enable bool, partSize int64) {
+	st.etag = enable
+	st.etagPartSize = partSize
+}
+
+// WithSymlinks set symlink handling mode, one of SymlinksFollow (default), SymlinksSkip or SymlinksPreserve.
+func (st *FSStorage) WithSymlinks(mode string) {
+	st.symlinks = mode
+}
+
+// WithChecksums enable loading of content checksums recorded in object metadata, see storage.ChecksumsToMetadata.
+// Recorded checksums are loaded only if file size and mtime are not changed since the checksums were recorded.
+func (st *FSStorage) WithChecksums(enable bool) {
+	st.checksums = enable
+}
+
+// objPath return FS path of object with given key.
+// It returns ErrKeyOutsideDir if the path is outside of storage directory.
+func (st *FSStorage) objPath(key string) (string, error) {
+	if st.keyEncoding {
+		key = encodeKey(key)
+	}
+	p := filepath.Join(st.dir, key)
+	rel, err := filepath.Rel(st.dir, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", ErrKeyOutsideDir
+	}
+	if isReservedPath(filepath.ToSlash(rel)) {
+		return "", ErrReservedKey
+	}
+	return p, nil
+}
+
+// objKey return object key of given FS path relative to storage directory.
+func (st *FSStorage) objKey(key string) string {
+	if st.keyEncoding {
+		decoded, err := decodeKey(filepath.ToSlash(key))
+		if err != nil {
+			storage.Log.Warnf("FS Listing: failed to decode path %s, err: %s, using it as is", key, err)
+			return key
+		}
+		return decoded
+	}
+	return key
+}
+
 // List FS and send founded objects to chan.
+//
+// Symlinks are handled according to the mode set by WithSymlinks.
+// In follow mode symlink loops are not walked, such symlinks are listed as objects
+// and reading of them fails with ErrSymlinkLoop.
 func (st *FSStorage) List(output chan<- *storage.Object) error {
+	walkDir := st.dir
+	if st.symlinks != SymlinksFollow {
+		// Storage dir itself can be a symlink, godirwalk does not walk it without following.
+		if realDir, err := filepath.EvalSymlinks(st.dir); err == nil {
+			walkDir = realDir + "/"
+		}
+	}
+
 	listObjectsFn := func(path string, de *godirwalk.Dirent) error {
 		select {
 		case <-st.ctx.Done():
 			return st.ctx.Err()
 		default:
+			if de.IsDir() && isReservedPath(strings.TrimPrefix(path, walkDir)) {
+				return filepath.SkipDir
+			}
 			if de.IsRegular() {
-				key := strings.TrimPrefix(path, st.dir)
+				key := st.objKey(strings.TrimPrefix(path, walkDir))
 				output <- &storage.Object{Key: &key}
 			}
 			if de.IsSymlink() {
-				pathTarget, err := filepath.EvalSymlinks(path)
-				if err != nil {
-					return err
+				switch st.symlinks {
+				case SymlinksSkip:
+					storage.Log.Debugf("FS Listing: %s is a symlink, skipping", path)
+					return nil
+				case SymlinksPreserve:
+					key := st.objKey(strings.TrimPrefix(path, walkDir))
+					output <- &storage.Object{Key: &key}
+					return nil
 				}
-				symStat, err := os.Stat(pathTarget)
-				if err != nil {
+
+				symStat, err := os.Stat(path)
+				if err != nil && !errors.Is(err, syscall.ELOOP) {
 					return err
 				}
-				if !symStat.IsDir() {
-					key := strings.TrimPrefix(path, st.dir)
-					output <- &storage.Object{Key: &key}
+				if err == nil && symStat.IsDir() {
+					loop, err := isSymlinkLoop(path)
+					if err != nil {
+						return err
+					}
+					if !loop {
+						return nil
+					}
 				}
+				key := st.objKey(strings.TrimPrefix(path, walkDir))
+				output <- &storage.Object{Key: &key}
+				// Do not let godirwalk walk or stat listed symlinks, it fails on loops.
+				return godirwalk.SkipThis
 			}
 			return nil
 		}
@@ -119,8 +230,8 @@ func (st *FSStorage) List(output chan<- *storage.Object) error {
 		return godirwalk.Halt
 	}
 
-	err := godirwalk.Walk(st.dir, &godirwalk.Options{
-		FollowSymbolicLinks: true,
+	err := godirwalk.Walk(walkDir, &godirwalk.Options{
+		FollowSymbolicLinks: st.symlinks == SymlinksFollow,
 		Unsorted:            true,
 		ScratchBuffer:       make([]byte, st.bufSize),
 		Callback:            listObjectsFn,
@@ -135,13 +246,34 @@ func (st *FSStorage) List(output chan<- *storage.Object) error {
 
 // PutObject saves object to FS.
 func (st *FSStorage) PutObject(obj *storage.Object) error {
-	originalPath := filepath.Join(st.dir, *obj.Key)
+	if obj.ContentStream != nil {
+		// Stream is closed on any error, so its producer is not blocked.
+		defer obj.ContentStream.Close()
+	}
+	originalPath, err := st.objPath(*obj.Key)
+	if err != nil {
+		return err
+	}
+	if st.symlinks == SymlinksPreserve && isSymlinkObject(obj) {
+		return st.putSymlink(obj, originalPath)
+	}
 	destPath := originalPath
 	if st.atomicWrite {
 		destPath += ".temp." + storage.GetInsecureRandString(tempFileSuffixLen)
 	}
+	if st.symlinks == SymlinksPreserve {
+		// Symlinks in storage directory can point outside of it, so objects are never written through it.
+		if err := st.checkPathSymlinks(originalPath); err != nil {
+			return err
+		}
+		if fileInfo, err := os.Lstat(originalPath); err == nil && fileInfo.Mode()&os.ModeSymlink != 0 && !st.atomicWrite {
+			if err := os.Remove(originalPath); err != nil {
+				return err
+			}
+		}
+	}
 
-	err := os.MkdirAll(filepath.Dir(destPath), st.dirPerm)
+	err = os.MkdirAll(filepath.Dir(destPath), st.dirPerm)
 	if err != nil {
 		return err
 	}
@@ -155,7 +287,6 @@ func (st *FSStorage) PutObject(obj *storage.Object) error {
 	var objReader io.Reader
 	if obj.ContentStream != nil {
 		objReader = obj.ContentStream
-		defer obj.ContentStream.Close()
 	} else {
 		objReader = bytes.NewReader(*obj.Content)
 	}
@@ -164,13 +295,31 @@ func (st *FSStorage) PutObject(obj *storage.Object) error {
 		return err
 	}
 
-	if st.xattr {
+	if st.meta != nil {
+		if obj.ChecksumCRC32C != nil || obj.ChecksumSHA256 != nil {
+			fileInfo, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			storage.ChecksumsToMetadata(obj)
+			storage.SetMetadata(obj, MetaChecksumStamp, checksumStamp(fileInfo.Size(), st.finalMtime(obj, fileInfo)))
+		}
 		data, err := json.Marshal(obj)
+		// Checksums metadata is stored only in FS, the object can be uploaded to other targets too.
+		storage.DeleteMetadata(obj, storage.MetaChecksumCRC32C)
+		storage.DeleteMetadata(obj, storage.MetaChecksumSHA256)
+		storage.DeleteMetadata(obj, MetaChecksumStamp)
 		if err != nil {
 			return err
 		}
 
-		if err := xattr.FSet(f, "user.s3sync.meta", data); err != nil {
+		if err := st.meta.Set(f, originalPath, MetaAttrObject, data); err != nil {
+			return err
+		}
+	}
+
+	if st.posixAttrs {
+		if err := st.restorePosixAttrs(f, obj); err != nil {
 			return err
 		}
 	}
@@ -186,27 +335,69 @@ func (st *FSStorage) PutObject(obj *storage.Object) error {
 
 // GetObjectContent read object content and metadata from FS.
 func (st *FSStorage) GetObjectContent(obj *storage.Object) error {
-	destPath := filepath.Join(st.dir, *obj.Key)
+	destPath, err := st.objPath(*obj.Key)
+	if err != nil {
+		return err
+	}
+
+	// Metadata is read before the content, so the file access time is not updated yet.
+	// ETag is computed from the content below, so the file is read only once.
+	if err := st.getObjectMeta(obj, false, false); err != nil {
+		return err
+	}
+
+	if st.symlinks == SymlinksPreserve && isSymlinkObject(obj) {
+		data, _, err := readSymlinkObject(destPath)
+		if err != nil {
+			return err
+		}
+		obj.Content = &data
+		obj.ContentLength = storage.ToPtr(int64(len(data)))
+		return nil
+	}
+
 	f, err := os.Open(destPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	var etagEntry etagCacheEntry
+	needETag := false
+	if st.etag && obj.ETag == nil {
+		fileInfo, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		etagEntry = st.newETagCacheEntry(fileInfo)
+		if etag, ok := st.cachedETag(f, destPath, etagEntry); ok {
+			obj.ETag = &etag
+		} else {
+			needETag = true
+		}
+	}
+
 	data, err := ioutil.ReadAll(ratelimit.NewReader(f, st.rlBucket))
 	if err != nil {
 		return err
 	}
 
 	dataSize := int64(len(data))
+	if needETag {
+		etag, err := computeETag(bytes.NewReader(data), dataSize, etagEntry.PartSize)
+		if err != nil {
+			return err
+		}
+		obj.ETag = &etag
+		// File could be changed while reading, do not cache ETag of other content.
+		if dataSize == etagEntry.Size {
+			st.cacheETag(f, destPath, etagEntry, etag)
+		}
+	}
 
 	obj.Content = &data
 	obj.ContentLength = &dataSize
 
-	if err := st.GetObjectMeta(obj); err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -214,11 +405,43 @@ func (st *FSStorage) GetObjectACL(obj *storage.Object) error {
 	return st.GetObjectMeta(obj)
 }
 
+// GetObjectTags read object tags from FS.
+// Tags are stored in xattr with other object metadata, so it is the same as GetObjectMeta.
+func (st *FSStorage) GetObjectTags(obj *storage.Object) error {
+	return st.GetObjectMeta(obj)
+}
+
+// GetObjectLock read object retention and legal hold from FS.
+// It stored in xattr with other object metadata, so it is the same as GetObjectMeta with object lock fields.
+func (st *FSStorage) GetObjectLock(obj *storage.Object) error {
+	return st.getObjectMeta(obj, true, true)
+}
+
 // GetObjectMeta update object metadata from FS.
+// Stored retention and legal hold are not loaded, see GetObjectLock.
 func (st *FSStorage) GetObjectMeta(obj *storage.Object) error {
-	destPath := filepath.Join(st.dir, *obj.Key)
+	return st.getObjectMeta(obj, false, true)
+}
+
+// getObjectMeta update object metadata from FS, with stored object lock fields if withLock is set
+// and with computed ETag if withETag is set.
+func (st *FSStorage) getObjectMeta(obj *storage.Object, withLock, withETag bool) error {
+	destPath, err := st.objPath(*obj.Key)
+	if err != nil {
+		return err
+	}
+	if st.symlinks == SymlinksPreserve {
+		if fileInfo, err := os.Lstat(destPath); err == nil && fileInfo.Mode()&os.ModeSymlink != 0 {
+			setSymlinkMeta(obj, fileInfo)
+			return nil
+		}
+	}
+
 	f, err := os.Open(destPath)
 	if err != nil {
+		if errors.Is(err, syscall.ELOOP) {
+			return ErrSymlinkLoop
+		}
 		return err
 	}
 	defer f.Close()
@@ -227,28 +450,31 @@ func (st *FSStorage) GetObjectMeta(obj *storage.Object) error {
 	if err != nil {
 		return err
 	}
+	if fileInfo.IsDir() {
+		if loop, _ := isSymlinkLoop(destPath); loop {
+			return ErrSymlinkLoop
+		}
+		return fmt.Errorf("%s is a directory", destPath)
+	}
 
-	if st.xattr {
-		if data, err := xattr.FGet(f, "user.s3sync.meta"); err == nil {
+	if st.meta != nil {
+		if data, err := st.meta.Get(f, destPath, MetaAttrObject); err == nil {
+			lockMode, lockRetainUntil, lockLegalHold := obj.ObjectLockMode, obj.ObjectLockRetainUntilDate, obj.ObjectLockLegalHoldStatus
 			err := json.Unmarshal(data, obj)
 			if err != nil {
 				return err
 			}
-		} else {
-			switch err.(type) {
-			case *xattr.Error:
-				if isNoXattrData(err) {
-					contentType := mime.TypeByExtension(filepath.Ext(destPath))
-					Mtime := fileInfo.ModTime()
-					obj.ContentType = &contentType
-					obj.Mtime = &Mtime
-					break
-				} else {
-					return err
-				}
-			default:
-				return err
+			if !withLock {
+				obj.ObjectLockMode, obj.ObjectLockRetainUntilDate, obj.ObjectLockLegalHoldStatus = lockMode, lockRetainUntil, lockLegalHold
 			}
+			st.loadChecksums(obj, fileInfo)
+		} else if errors.Is(err, ErrNoMeta) {
+			contentType := mime.TypeByExtension(filepath.Ext(destPath))
+			Mtime := fileInfo.ModTime()
+			obj.ContentType = &contentType
+			obj.Mtime = &Mtime
+		} else {
+			return err
 		}
 	} else {
 		contentType := mime.TypeByExtension(filepath.Ext(destPath))
@@ -257,16 +483,37 @@ func (st *FSStorage) GetObjectMeta(obj *storage.Object) error {
 		obj.Mtime = &Mtime
 	}
 
+	obj.ContentLength = storage.ToPtr(fileInfo.Size())
+
+	if withETag && st.etag && obj.ETag == nil {
+		if err := st.loadETag(obj, f, destPath, fileInfo); err != nil {
+			return err
+		}
+	}
+
+	if st.posixAttrs {
+		st.setPosixAttrsMeta(obj, fileInfo)
+	}
+
 	return nil
 }
 
 // DeleteObject remove object from FS.
 func (st *FSStorage) DeleteObject(obj *storage.Object) error {
-	destPath := filepath.Join(st.dir, *obj.Key)
-	err := os.Remove(destPath)
+	destPath, err := st.objPath(*obj.Key)
 	if err != nil {
 		return err
 	}
+	err = os.Remove(destPath)
+	if err != nil {
+		return err
+	}
+
+	if st.meta != nil {
+		if err := st.meta.Delete(destPath); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,124 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Key encoding tokens.
+// Literal "%" is always escaped as "%25", so this tokens can't be produced by encoding of key symbols.
+const (
+	keyEncEmptySegment = "%"
+	keyEncDirMarker    = "%!"
+	keyEncContinuation = "%~"
+	keyEncMaxSegment   = 200
+	keyEncUnsafeChars  = "%\\:*?\"<>|"
+)
+
+// ErrKeyOutsideDir raises when object key points outside of storage directory.
+var ErrKeyOutsideDir = errors.New("object key points outside of storage directory")
+
+// encodeKey convert object key to a FS-safe relative path.
+//
+// Each key segment (part between "/") is encoded separately:
+//   - empty segments (leading or double slashes) are encoded as "%", trailing slash is encoded as "%!"
+//   - "." and ".." segments are escaped
+//   - control chars, "%" and chars illegal on some filesystems are percent-encoded, as well as trailing dot and space
+//   - segments longer than keyEncMaxSegment bytes are split into nested dirs, each part except the last ends with "%~"
+//
+// The encoding is reversible with decodeKey.
+func encodeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = encodeKeySegment(seg)
+	}
+	if segments[len(segments)-1] == keyEncEmptySegment {
+		// Trailing empty segment is a file, so it should not conflict with the dir of the middle empty segment.
+		segments[len(segments)-1] = keyEncDirMarker
+	}
+	return strings.Join(segments, "/")
+}
+
+func encodeKeySegment(seg string) string {
+	switch seg {
+	case "":
+		return keyEncEmptySegment
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+
+	sb := strings.Builder{}
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		if c < 0x20 || c == 0x7F || strings.IndexByte(keyEncUnsafeChars, c) >= 0 ||
+			(i == len(seg)-1 && (c == '.' || c == ' ')) {
+			_, _ = fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	enc := sb.String()
+
+	parts := make([]string, 0, 1)
+	for len(enc) > keyEncMaxSegment {
+		cut := keyEncMaxSegment
+		for cut > 0 && !utf8.RuneStart(enc[cut]) {
+			cut--
+		}
+		if enc[cut-1] == '%' {
+			cut--
+		} else if enc[cut-2] == '%' {
+			cut -= 2
+		}
+		parts = append(parts, enc[:cut]+keyEncContinuation)
+		enc = enc[cut:]
+	}
+	parts = append(parts, enc)
+
+	return strings.Join(parts, "/")
+}
+
+// decodeKey convert relative path produced by encodeKey back to object key.
+func decodeKey(p string) (string, error) {
+	segments := strings.Split(p, "/")
+	res := make([]string, 0, len(segments))
+	cur := strings.Builder{}
+	for i, seg := range segments {
+		if i == len(segments)-1 && seg == keyEncDirMarker && cur.Len() == 0 {
+			res = append(res, "")
+			continue
+		}
+
+		if strings.HasSuffix(seg, keyEncContinuation) {
+			dec, err := url.PathUnescape(strings.TrimSuffix(seg, keyEncContinuation))
+			if err != nil {
+				return "", err
+			}
+			cur.WriteString(dec)
+			continue
+		}
+
+		if seg == keyEncEmptySegment && cur.Len() == 0 {
+			res = append(res, "")
+			continue
+		}
+
+		dec, err := url.PathUnescape(seg)
+		if err != nil {
+			return "", err
+		}
+		cur.WriteString(dec)
+		res = append(res, cur.String())
+		cur.Reset()
+	}
+
+	if cur.Len() > 0 {
+		return "", fmt.Errorf("path %s has unterminated long segment", p)
+	}
+	return strings.Join(res, "/"), nil
+}
@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/larrabee/s3sync/storage"
+	"github.com/pkg/xattr"
+)
+
+// SidecarMetaDir is the name of the dir in the storage root where sidecar meta store keeps metadata files.
+// This dir is reserved regardless of used meta store: it is never listed and objects can't be written to it.
+const SidecarMetaDir = ".s3sync-meta"
+
+// Names of metadata attributes stored in MetaStore.
+const (
+	// MetaAttrObject is the JSON encoded storage.Object.
+	MetaAttrObject = "meta"
+	// MetaAttrETag is the cache of computed file ETag.
+	MetaAttrETag = "etag"
+)
+
+var metaAttrs = []string{MetaAttrObject, MetaAttrETag}
+
+const (
+	xattrMetaPrefix  = "user.s3sync."
+	sidecarTempInfix = ".temp."
+)
+
+// ErrNoMeta raises when file has no stored metadata.
+var ErrNoMeta = errors.New("file has no stored metadata")
+
+// ErrReservedKey raises when object key points to the SidecarMetaDir.
+var ErrReservedKey = errors.New("object key is reserved for storage metadata")
+
+// isReservedPath check that path relative to storage root is inside of SidecarMetaDir.
+func isReservedPath(rel string) bool {
+	return rel == SidecarMetaDir || strings.HasPrefix(rel, SidecarMetaDir+"/")
+}
+
+// MetaStore is a storage of FS objects metadata attributes.
+type MetaStore interface {
+	// Get return metadata attribute of the file. It returns ErrNoMeta if the file has no stored attribute.
+	Get(file *os.File, path, name string) ([]byte, error)
+	// Set save metadata attribute of the file. On atomic writes file is the temp file and path is the final file path.
+	Set(file *os.File, path, name string, data []byte) error
+	// Delete remove all metadata attributes of the file with given path.
+	Delete(path string) error
+}
+
+// XattrMetaStore store metadata attributes in the user.s3sync.<name> extended attributes of the file.
+type XattrMetaStore struct{}
+
+// Get return metadata attribute from file xattr.
+func (XattrMetaStore) Get(file *os.File, path, name string) ([]byte, error) {
+	data, err := xattr.FGet(file, xattrMetaPrefix+name)
+	if err != nil && isNoXattrData(err) {
+		return nil, ErrNoMeta
+	}
+	return data, err
+}
+
+// Set save metadata attribute to file xattr.
+func (XattrMetaStore) Set(file *os.File, path, name string, data []byte) error {
+	return xattr.FSet(file, xattrMetaPrefix+name, data)
+}
+
+// Delete do nothing, xattr are removed with the file.
+func (XattrMetaStore) Delete(path string) error {
+	return nil
+}
+
+// SidecarMetaStore store metadata attributes in the separate files in the SidecarMetaDir tree of the storage root.
+// Attribute of file "dir/file" is stored in ".s3sync-meta/dir/file/%<name>". "%" in file paths is escaped as "%25",
+// so attribute files never conflict with the metadata dirs of other files.
+// Use it on filesystems without xattr support.
+//
+// Sidecar files are not updated if data files are changed by other tools,
+// so the metadata may become stale.
+type SidecarMetaStore struct {
+	root     string
+	filePerm os.FileMode
+	dirPerm  os.FileMode
+}
+
+// NewSidecarMetaStore return new SidecarMetaStore for the storage in dir.
+func NewSidecarMetaStore(dir string, filePerm, dirPerm os.FileMode) *SidecarMetaStore {
+	return &SidecarMetaStore{
+		root:     filepath.Clean(dir) + "/",
+		filePerm: filePerm,
+		dirPerm:  dirPerm,
+	}
+}
+
+// metaDir return the dir of sidecar files of the file with given path.
+func (ms *SidecarMetaStore) metaDir(path string) string {
+	return filepath.Join(ms.root, SidecarMetaDir, strings.ReplaceAll(strings.TrimPrefix(path, ms.root), "%", "%25"))
+}
+
+// metaPath return the sidecar file path of the attribute of the file with given path.
+func (ms *SidecarMetaStore) metaPath(path, name string) string {
+	return filepath.Join(ms.metaDir(path), "%"+name)
+}
+
+// Get return metadata attribute from the sidecar file.
+func (ms *SidecarMetaStore) Get(file *os.File, path, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(ms.metaPath(path, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoMeta
+	}
+	return data, err
+}
+
+// Set save metadata attribute to the sidecar file.
+// The file is written to temp file and renamed, so parallel readers never get partial metadata.
+func (ms *SidecarMetaStore) Set(file *os.File, path, name string, data []byte) error {
+	metaPath := ms.metaPath(path, name)
+	if err := os.MkdirAll(filepath.Dir(metaPath), ms.dirPerm); err != nil {
+		return err
+	}
+	tempPath := metaPath + sidecarTempInfix + storage.GetInsecureRandString(tempFileSuffixLen)
+	if err := ioutil.WriteFile(tempPath, data, ms.filePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, metaPath); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// Delete remove the sidecar files of all attributes.
+// The metadata dir of the file is removed if it is empty, it is kept if there are metadata dirs of nested files.
+func (ms *SidecarMetaStore) Delete(path string) error {
+	for _, name := range metaAttrs {
+		err := os.Remove(ms.metaPath(path, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	_ = os.Remove(ms.metaDir(path))
+	return nil
+}
@@ -0,0 +1,173 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/larrabee/s3sync/storage"
+)
+
+// Symlink handling modes.
+const (
+	// SymlinksFollow read symlink targets as regular files and dirs.
+	SymlinksFollow = "follow"
+	// SymlinksSkip ignore symlinks.
+	SymlinksSkip = "skip"
+	// SymlinksPreserve store symlink as an object with link target as content and MetaSymlink metadata.
+	// On FS target such objects are restored as symlinks, links pointing outside of storage directory are rejected
+	// and objects are never written through symlinked dirs.
+	SymlinksPreserve = "preserve"
+)
+
+// MetaSymlink is the metadata key that marks objects created from symlinks.
+const MetaSymlink = "S3sync-Symlink"
+
+// ErrSymlinkLoop raises when symlink points to itself or to one of its parent dirs.
+var ErrSymlinkLoop = errors.New("symlink loop detected")
+
+// ErrSymlinkOutsideDir raises when symlink object points outside of storage directory.
+// It is a permission error, see storage.IsErrPermission.
+var ErrSymlinkOutsideDir = fmt.Errorf("symlink target is outside of storage directory: %w", os.ErrPermission)
+
+// ErrSymlinkInPath raises when one of object parent dirs is a symlink, so the object can be written outside of storage directory.
+// It is a permission error, see storage.IsErrPermission.
+var ErrSymlinkInPath = fmt.Errorf("object path contains symlink: %w", os.ErrPermission)
+
+// isSymlinkLoop check that the symlink at path is a part of a symlink loop.
+// Symlink is a part of loop if it can't be resolved because of too many links,
+// or if it points to one of dirs on the path to it.
+func isSymlinkLoop(path string) (bool, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if _, statErr := os.Stat(path); errors.Is(statErr, syscall.ELOOP) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return false, err
+		}
+		if realDir == target {
+			return true, nil
+		}
+		if dir == filepath.Dir(dir) {
+			return false, nil
+		}
+	}
+}
+
+// isSymlinkObject check that object was created from symlink in preserve mode.
+func isSymlinkObject(obj *storage.Object) bool {
+	_, ok := storage.GetMetadata(obj, MetaSymlink)
+	return ok
+}
+
+// readSymlinkObject return symlink object content.
+func readSymlinkObject(path string) ([]byte, os.FileInfo, error) {
+	fileInfo, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(target), fileInfo, nil
+}
+
+// setSymlinkMeta update object metadata from symlink info.
+func setSymlinkMeta(obj *storage.Object, fileInfo os.FileInfo) {
+	mtime := fileInfo.ModTime()
+	obj.Mtime = &mtime
+	obj.ContentType = nil
+	storage.SetMetadata(obj, MetaSymlink, "true")
+}
+
+// putSymlink create symlink at originalPath with target read from object content.
+func (st *FSStorage) putSymlink(obj *storage.Object, originalPath string) error {
+	var target []byte
+	if obj.ContentStream != nil {
+		buf := &bytes.Buffer{}
+		if _, err := io.Copy(buf, obj.ContentStream); err != nil {
+			return err
+		}
+		target = buf.Bytes()
+	} else if obj.Content != nil {
+		target = *obj.Content
+	}
+	if len(target) == 0 {
+		return errors.New("symlink object has empty target")
+	}
+	if err := st.checkSymlinkTarget(originalPath, string(target)); err != nil {
+		return err
+	}
+	if err := st.checkPathSymlinks(originalPath); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(originalPath), st.dirPerm); err != nil {
+		return err
+	}
+
+	if st.atomicWrite {
+		tempPath := originalPath + ".temp." + storage.GetInsecureRandString(tempFileSuffixLen)
+		if err := os.Symlink(string(target), tempPath); err != nil {
+			return err
+		}
+		return os.Rename(tempPath, originalPath)
+	}
+
+	if err := os.Remove(originalPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(string(target), originalPath)
+}
+
+// checkSymlinkTarget check that symlink at path with given target points inside storage directory.
+// Absolute targets are not allowed, relative targets are checked lexically without following other symlinks,
+// so writes through symlinks are rejected by checkPathSymlinks too.
+func (st *FSStorage) checkSymlinkTarget(path, target string) error {
+	if filepath.IsAbs(target) {
+		return ErrSymlinkOutsideDir
+	}
+	rel, err := filepath.Rel(st.dir, filepath.Join(filepath.Dir(path), target))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return ErrSymlinkOutsideDir
+	}
+	return nil
+}
+
+// checkPathSymlinks check that parent dirs of path inside storage directory are not symlinks,
+// so objects can't be written through preserved symlinks outside of storage directory.
+func (st *FSStorage) checkPathSymlinks(path string) error {
+	rel, err := filepath.Rel(st.dir, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	dir := st.dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		fileInfo, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			return ErrSymlinkInPath
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"strings"
+)
+
+// PrefixStorage is a Storage wrapper that adds the prefix to keys of listed objects
+// and removes it from keys of requested objects.
+// It allows to use storage keys under the prefix of another storage.
+// You should always create new PrefixStorage with NewPrefixStorage constructor.
+type PrefixStorage struct {
+	Storage
+	prefix string
+}
+
+// NewPrefixStorage return a new PrefixStorage wrapping given storage.
+func NewPrefixStorage(st Storage, prefix string) *PrefixStorage {
+	return &PrefixStorage{
+		Storage: st,
+		prefix:  prefix,
+	}
+}
+
+// List list objects of the underlying storage and add the prefix to its keys.
+func (st *PrefixStorage) List(ch chan<- *Object) error {
+	listCh := make(chan *Object)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for obj := range listCh {
+			obj.Key = ToPtr(st.prefix + *obj.Key)
+			ch <- obj
+		}
+	}()
+	err := st.Storage.List(listCh)
+	close(listCh)
+	<-done
+	return err
+}
+
+// PutObject saves object to the underlying storage without the prefix.
+func (st *PrefixStorage) PutObject(obj *Object) error {
+	return st.withKey(obj, st.Storage.PutObject)
+}
+
+// GetObjectContent read object content and metadata from the underlying storage without the prefix.
+func (st *PrefixStorage) GetObjectContent(obj *Object) error {
+	return st.withKey(obj, st.Storage.GetObjectContent)
+}
+
+// GetObjectMeta update object metadata from the underlying storage without the prefix.
+func (st *PrefixStorage) GetObjectMeta(obj *Object) error {
+	return st.withKey(obj, st.Storage.GetObjectMeta)
+}
+
+// GetObjectACL read object ACL from the underlying storage without the prefix.
+func (st *PrefixStorage) GetObjectACL(obj *Object) error {
+	return st.withKey(obj, st.Storage.GetObjectACL)
+}
+
+// GetObjectTags read object tags from the underlying storage without the prefix.
+func (st *PrefixStorage) GetObjectTags(obj *Object) error {
+	return st.withKey(obj, st.Storage.GetObjectTags)
+}
+
+// GetObjectLock read object retention and legal hold from the underlying storage without the prefix.
+func (st *PrefixStorage) GetObjectLock(obj *Object) error {
+	return st.withKey(obj, st.Storage.GetObjectLock)
+}
+
+// DeleteObject remove object from the underlying storage without the prefix.
+func (st *PrefixStorage) DeleteObject(obj *Object) error {
+	return st.withKey(obj, st.Storage.DeleteObject)
+}
+
+// withKey call fn with the object key without the prefix and restore the key after call.
+func (st *PrefixStorage) withKey(obj *Object, fn func(*Object) error) error {
+	if st.prefix == "" {
+		return fn(obj)
+	}
+	key := obj.Key
+	obj.Key = ToPtr(strings.TrimPrefix(*key, st.prefix))
+	defer func() { obj.Key = key }()
+	return fn(obj)
+}
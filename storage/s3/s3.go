@@ -35,6 +35,8 @@ type S3Storage struct {
 	ctx           context.Context
 	listMarker    *string
 	rlBucket      ratelimit.Bucket
+	sse           SSEConfig
+	checksums     bool
 	serverGzip    bool
 }
 
@@ -106,6 +108,16 @@ func (st *S3Storage) WithRateLimit(limit int) error {
 	return nil
 }
 
+// WithSSE set server-side encryption settings for storage.
+func (st *S3Storage) WithSSE(cfg SSEConfig) {
+	st.sse = cfg
+}
+
+// WithChecksums enable reading of object checksums from S3 (x-amz-checksum-mode).
+func (st *S3Storage) WithChecksums(enable bool) {
+	st.checksums = enable
+}
+
 // List S3 bucket and send founded objects to chan.
 func (st *S3Storage) List(output chan<- *storage.Object) error {
 	listObjectsFn := func(p *s3.ListObjectsV2Output, lastPage bool) bool {
@@ -113,11 +125,12 @@ func (st *S3Storage) List(output chan<- *storage.Object) error {
 			key, _ := url.QueryUnescape(aws.StringValue(o.Key))
 			key = strings.Replace(key, st.prefix, "", 1)
 			output <- &storage.Object{
-				Key:          &key,
-				ETag:         storage.StrongEtag(o.ETag),
-				Mtime:        o.LastModified,
-				StorageClass: o.StorageClass,
-				IsLatest:     aws.Bool(true),
+				Key:           &key,
+				ETag:          storage.StrongEtag(o.ETag),
+				Mtime:         o.LastModified,
+				ContentLength: o.Size,
+				StorageClass:  o.StorageClass,
+				IsLatest:      aws.Bool(true),
 			}
 		}
 		st.listMarker = p.NextContinuationToken
@@ -140,42 +153,81 @@ func (st *S3Storage) List(output chan<- *storage.Object) error {
 
 }
 
+// ListParallel list S3 bucket with concurrent requests by top level dirs and send founded objects to chan, see ListParallel func.
+func (st *S3Storage) ListParallel(output chan<- *storage.Object, workers int) error {
+	listPages := func(prefix, delimiter string, fn func(objects []*s3.Object, prefixes []*s3.CommonPrefix)) error {
+		input := &s3.ListObjectsV2Input{
+			Bucket:       st.awsBucket,
+			Prefix:       aws.String(prefix),
+			MaxKeys:      aws.Int64(st.keysPerReq),
+			EncodingType: aws.String(s3.EncodingTypeUrl),
+		}
+		if delimiter != "" {
+			input.Delimiter = aws.String(delimiter)
+		}
+		return st.awsSvc.ListObjectsV2PagesWithContext(st.ctx, input, func(p *s3.ListObjectsV2Output, lastPage bool) bool {
+			fn(p.Contents, p.CommonPrefixes)
+			return !lastPage
+		})
+	}
+	if err := ListParallel(listPages, st.prefix, workers, output); err != nil {
+		return err
+	}
+	storage.Log.Debugf("Listing bucket finished")
+	return nil
+}
+
 // PutObject saves object to S3.
 // PutObject ignore VersionId, it always save object as latest version.
 func (st *S3Storage) PutObject(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
 	var objReader io.ReadSeeker
 	if obj.Content == nil {
 		if obj.ContentStream == nil {
 			return errors.New("object has no content")
 		}
+		defer obj.ContentStream.Close()
 		buf := bytes.NewBuffer(make([]byte, 0, aws.Int64Value(obj.ContentLength)))
 		if _, err := io.Copy(ratelimit.NewWriter(buf, st.rlBucket), obj.ContentStream); err != nil {
 			return err
 		}
-		obj.ContentStream.Close()
 		objReader = bytes.NewReader(buf.Bytes())
 	} else {
 		objReader = bytes.NewReader(*obj.Content)
 	}
 
 	rlReader := ratelimit.NewReadSeeker(objReader, st.rlBucket)
+	// Checksums of content stream are known only after it is read.
+	checksumCRC32C, checksumSHA256 := ChecksumHeaders(obj)
 
 	input := &s3.PutObjectInput{
-		Bucket:               st.awsBucket,
-		Key:                  aws.String(st.prefix + *obj.Key),
-		Body:                 rlReader,
-		ContentType:          obj.ContentType,
-		ContentDisposition:   obj.ContentDisposition,
-		ContentEncoding:      obj.ContentEncoding,
-		ContentLanguage:      obj.ContentLanguage,
-		ACL:                  obj.ACL,
-		Metadata:             obj.Metadata,
-		CacheControl:         obj.CacheControl,
-		StorageClass:         obj.StorageClass,
-		ServerSideEncryption: obj.ServerSideEncryption,
-	}
-
-	if _, err := st.awsSvc.PutObjectWithContext(st.ctx, input); err != nil {
+		Bucket:                    st.awsBucket,
+		Key:                       aws.String(st.prefix + *obj.Key),
+		Body:                      rlReader,
+		ContentType:               obj.ContentType,
+		ContentDisposition:        obj.ContentDisposition,
+		ContentEncoding:           obj.ContentEncoding,
+		ContentLanguage:           obj.ContentLanguage,
+		ACL:                       obj.ACL,
+		Metadata:                  obj.Metadata,
+		CacheControl:              obj.CacheControl,
+		StorageClass:              obj.StorageClass,
+		ServerSideEncryption:      st.sse.ServerSideEncryption(obj.ServerSideEncryption),
+		SSEKMSKeyId:               st.sse.KMSKeyId,
+		BucketKeyEnabled:          st.sse.BucketKeyEnabled,
+		SSECustomerAlgorithm:      st.sse.CustomerAlgorithm(),
+		SSECustomerKey:            st.sse.CustomerKey,
+		Tagging:                   EncodeTags(obj.Tags),
+		ObjectLockMode:            obj.ObjectLockMode,
+		ObjectLockRetainUntilDate: obj.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: obj.ObjectLockLegalHoldStatus,
+		ChecksumCRC32C:            checksumCRC32C,
+		ChecksumSHA256:            checksumSHA256,
+	}
+
+	if _, err := st.awsSvc.PutObjectWithContext(ctx, input); err != nil {
 		return err
 	}
 
@@ -186,7 +238,7 @@ func (st *S3Storage) PutObject(obj *storage.Object) error {
 			AccessControlPolicy: obj.AccessControlPolicy,
 		}
 
-		if _, err := st.awsSvc.PutObjectAclWithContext(st.ctx, inputAcl); err != nil {
+		if _, err := st.awsSvc.PutObjectAclWithContext(ctx, inputAcl); err != nil {
 			return err
 		}
 	}
@@ -196,10 +248,16 @@ func (st *S3Storage) PutObject(obj *storage.Object) error {
 
 // GetObjectContent read object content and metadata from S3.
 func (st *S3Storage) GetObjectContent(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
 	input := &s3.GetObjectInput{
-		Bucket:    st.awsBucket,
-		Key:       aws.String(st.prefix + *obj.Key),
-		VersionId: obj.VersionId,
+		Bucket:               st.awsBucket,
+		Key:                  aws.String(st.prefix + *obj.Key),
+		VersionId:            obj.VersionId,
+		SSECustomerAlgorithm: st.sse.CustomerAlgorithm(),
+		SSECustomerKey:       st.sse.CustomerKey,
+		ChecksumMode:         ChecksumMode(st.checksums),
 	}
 
 	opts := make([]request.Option, 0, 1)
@@ -207,7 +265,7 @@ func (st *S3Storage) GetObjectContent(obj *storage.Object) error {
 		opts = append(opts, withAcceptEncoding("gzip"))
 	}
 
-	result, err := st.awsSvc.GetObjectWithContext(st.ctx, input, opts...)
+	result, err := st.awsSvc.GetObjectWithContext(ctx, input, opts...)
 	if err != nil {
 		return err
 	}
@@ -227,21 +285,27 @@ func (st *S3Storage) GetObjectContent(obj *storage.Object) error {
 	obj.ETag = storage.StrongEtag(result.ETag)
 	obj.Metadata = result.Metadata
 	obj.Mtime = result.LastModified
+	obj.ContentLength = result.ContentLength
 	obj.CacheControl = result.CacheControl
 	obj.StorageClass = result.StorageClass
+	obj.ChecksumCRC32C = storage.FullObjectChecksum(result.ChecksumCRC32C)
+	obj.ChecksumSHA256 = storage.FullObjectChecksum(result.ChecksumSHA256)
 
 	return nil
 }
 
 // GetObjectACL read object ACL from S3.
 func (st *S3Storage) GetObjectACL(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
 	input := &s3.GetObjectAclInput{
 		Bucket:    st.awsBucket,
 		Key:       aws.String(st.prefix + *obj.Key),
 		VersionId: obj.VersionId,
 	}
 
-	result, err := st.awsSvc.GetObjectAclWithContext(st.ctx, input)
+	result, err := st.awsSvc.GetObjectAclWithContext(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -254,15 +318,79 @@ func (st *S3Storage) GetObjectACL(obj *storage.Object) error {
 	return nil
 }
 
-// GetObjectMeta update object metadata from S3.
-func (st *S3Storage) GetObjectMeta(obj *storage.Object) error {
-	input := &s3.HeadObjectInput{
+// GetObjectTags read object tags from S3.
+func (st *S3Storage) GetObjectTags(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
+	input := &s3.GetObjectTaggingInput{
+		Bucket:    st.awsBucket,
+		Key:       aws.String(st.prefix + *obj.Key),
+		VersionId: obj.VersionId,
+	}
+
+	result, err := st.awsSvc.GetObjectTaggingWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	obj.Tags = DecodeTags(result.TagSet)
+
+	return nil
+}
+
+// GetObjectLock read object retention and legal hold from S3.
+func (st *S3Storage) GetObjectLock(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
+	inputRetention := &s3.GetObjectRetentionInput{
+		Bucket:    st.awsBucket,
+		Key:       aws.String(st.prefix + *obj.Key),
+		VersionId: obj.VersionId,
+	}
+
+	retention, err := st.awsSvc.GetObjectRetentionWithContext(ctx, inputRetention)
+	if err != nil && !IsErrNoObjectLockConfiguration(err) {
+		return err
+	}
+	if err == nil && retention.Retention != nil {
+		obj.ObjectLockMode = retention.Retention.Mode
+		obj.ObjectLockRetainUntilDate = retention.Retention.RetainUntilDate
+	}
+
+	inputLegalHold := &s3.GetObjectLegalHoldInput{
 		Bucket:    st.awsBucket,
 		Key:       aws.String(st.prefix + *obj.Key),
 		VersionId: obj.VersionId,
 	}
 
-	result, err := st.awsSvc.HeadObjectWithContext(st.ctx, input)
+	legalHold, err := st.awsSvc.GetObjectLegalHoldWithContext(ctx, inputLegalHold)
+	if err != nil && !IsErrNoObjectLockConfiguration(err) {
+		return err
+	}
+	if err == nil && legalHold.LegalHold != nil {
+		obj.ObjectLockLegalHoldStatus = legalHold.LegalHold.Status
+	}
+
+	return nil
+}
+
+// GetObjectMeta update object metadata from S3.
+func (st *S3Storage) GetObjectMeta(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
+	input := &s3.HeadObjectInput{
+		Bucket:               st.awsBucket,
+		Key:                  aws.String(st.prefix + *obj.Key),
+		VersionId:            obj.VersionId,
+		SSECustomerAlgorithm: st.sse.CustomerAlgorithm(),
+		SSECustomerKey:       st.sse.CustomerKey,
+		ChecksumMode:         ChecksumMode(st.checksums),
+	}
+
+	result, err := st.awsSvc.HeadObjectWithContext(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -274,21 +402,27 @@ func (st *S3Storage) GetObjectMeta(obj *storage.Object) error {
 	obj.ETag = storage.StrongEtag(result.ETag)
 	obj.Metadata = result.Metadata
 	obj.Mtime = result.LastModified
+	obj.ContentLength = result.ContentLength
 	obj.CacheControl = result.CacheControl
 	obj.StorageClass = result.StorageClass
+	obj.ChecksumCRC32C = storage.FullObjectChecksum(result.ChecksumCRC32C)
+	obj.ChecksumSHA256 = storage.FullObjectChecksum(result.ChecksumSHA256)
 
 	return nil
 }
 
 // DeleteObject remove object from S3.
 func (st *S3Storage) DeleteObject(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
 	input := &s3.DeleteObjectInput{
 		Bucket:    st.awsBucket,
 		Key:       aws.String(st.prefix + *obj.Key),
 		VersionId: obj.VersionId,
 	}
 
-	if _, err := st.awsSvc.DeleteObjectWithContext(st.ctx, input); err != nil {
+	if _, err := st.awsSvc.DeleteObjectWithContext(ctx, input); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SSEConfig contain server-side encryption settings of S3 storage.
+type SSEConfig struct {
+	// KMSKeyId is the ID or ARN of KMS key used for uploaded objects.
+	// If set, uploaded objects will be encrypted with "aws:kms" unless object has another SSE mode.
+	KMSKeyId *string
+
+	// BucketKeyEnabled enables S3 Bucket Key for SSE-KMS encrypted uploads.
+	BucketKeyEnabled *bool
+
+	// CustomerKey is the SSE-C key (256 bit).
+	// If set, it is used for all upload, download and head requests.
+	CustomerKey *string
+}
+
+// CustomerAlgorithm return SSE-C algorithm if customer key is set, else nil.
+func (c SSEConfig) CustomerAlgorithm() *string {
+	if aws.StringValue(c.CustomerKey) == "" {
+		return nil
+	}
+	return aws.String(s3.ServerSideEncryptionAes256)
+}
+
+// ServerSideEncryption return SSE mode for uploaded object.
+func (c SSEConfig) ServerSideEncryption(objSSE *string) *string {
+	if objSSE == nil && aws.StringValue(c.KMSKeyId) != "" {
+		return aws.String(s3.ServerSideEncryptionAwsKms)
+	}
+	return objSSE
+}
@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/larrabee/s3sync/storage"
+)
+
+// EncodeTags convert object tags to URL query format used by the x-amz-tagging header.
+// It returns nil if there are no tags.
+func EncodeTags(tags map[string]*string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, aws.StringValue(v))
+	}
+	return aws.String(values.Encode())
+}
+
+// DecodeTags convert S3 tag set to object tags.
+func DecodeTags(tagSet []*s3.Tag) map[string]*string {
+	tags := make(map[string]*string, len(tagSet))
+	for _, t := range tagSet {
+		tags[aws.StringValue(t.Key)] = t.Value
+	}
+	return tags
+}
+
+// IsErrNoObjectLockConfiguration check that object has no retention or legal hold.
+func IsErrNoObjectLockConfiguration(err error) bool {
+	var aErr awserr.Error
+	if errors.As(err, &aErr) && aErr.Code() == "NoSuchObjectLockConfiguration" {
+		return true
+	}
+	return false
+}
+
+// ChecksumHeaders return object checksum to send on upload.
+// S3 accepts only one checksum header per request, so CRC32C is preferred if both checksums are set.
+func ChecksumHeaders(obj *storage.Object) (crc32c, sha256 *string) {
+	if v := storage.FullObjectChecksum(obj.ChecksumCRC32C); v != nil {
+		return v, nil
+	}
+	return nil, storage.FullObjectChecksum(obj.ChecksumSHA256)
+}
+
+// ChecksumMode return value of x-amz-checksum-mode header.
+func ChecksumMode(enable bool) *string {
+	if enable {
+		return aws.String(s3.ChecksumModeEnabled)
+	}
+	return nil
+}
+
+// ListPagesFunc list objects with given key prefix and delimiter and call fn for every page of listing.
+// Keys and prefixes are returned as is, in the encoding of the listing request.
+type ListPagesFunc func(prefix, delimiter string, fn func(objects []*s3.Object, prefixes []*s3.CommonPrefix)) error
+
+// ListParallel list objects under the storage prefix and send them to output.
+// The top level dirs of the prefix (common prefixes with "/" delimiter) are listed concurrently by given number of workers,
+// so buckets without dirs are listed with one request at a time.
+// Listed keys are URL decoded and the storage prefix is removed like in storage List.
+func ListParallel(listPages ListPagesFunc, prefix string, workers int, output chan<- *storage.Object) error {
+	send := func(objects []*s3.Object) {
+		for _, o := range objects {
+			key, _ := url.QueryUnescape(aws.StringValue(o.Key))
+			key = strings.Replace(key, prefix, "", 1)
+			output <- &storage.Object{
+				Key:           &key,
+				ETag:          storage.StrongEtag(o.ETag),
+				Mtime:         o.LastModified,
+				ContentLength: o.Size,
+				StorageClass:  o.StorageClass,
+				IsLatest:      aws.Bool(true),
+			}
+		}
+	}
+
+	var dirs []string
+	err := listPages(prefix, "/", func(objects []*s3.Object, prefixes []*s3.CommonPrefix) {
+		send(objects)
+		for _, p := range prefixes {
+			dir, _ := url.QueryUnescape(aws.StringValue(p.Prefix))
+			dirs = append(dirs, dir)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	dirChan := make(chan string)
+	errChan := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range dirChan {
+				err := listPages(dir, "", func(objects []*s3.Object, prefixes []*s3.CommonPrefix) {
+					send(objects)
+				})
+				if err != nil {
+					errChan <- err
+					// Drain the rest of dirs, the listing is failed anyway.
+					for range dirChan {
+					}
+					return
+				}
+			}
+		}()
+	}
+	for _, dir := range dirs {
+		dirChan <- dir
+	}
+	close(dirChan)
+	wg.Wait()
+	close(errChan)
+	return <-errChan
+}
@@ -0,0 +1,90 @@
+package s3stream
+
+import (
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// uploadChecksumOption return request option that adds content checksums of given algorithm to the requests of one upload.
+// The uploader does not compute checksums, so the option computes the checksum of PutObject body for single part uploads,
+// of each UploadPart body for multipart uploads and set the checksums of parts in CompleteMultipartUpload.
+// S3 verifies the checksum of each request body.
+func uploadChecksumOption(algorithm string) request.Option {
+	var mu sync.Mutex
+	parts := make(map[int64]*string)
+
+	return func(r *request.Request) {
+		r.Handlers.Build.PushFront(func(r *request.Request) {
+			switch params := r.Params.(type) {
+			case *s3.PutObjectInput:
+				if params.ChecksumCRC32C != nil || params.ChecksumSHA256 != nil {
+					// Full object checksum is known, it is verified by S3 instead.
+					params.ChecksumAlgorithm = nil
+					return
+				}
+				sum, err := bodyChecksum(params.Body, algorithm)
+				if err != nil {
+					r.Error = err
+					return
+				}
+				setChecksum(&params.ChecksumCRC32C, &params.ChecksumSHA256, algorithm, sum)
+			case *s3.UploadPartInput:
+				sum, err := bodyChecksum(params.Body, algorithm)
+				if err != nil {
+					r.Error = err
+					return
+				}
+				setChecksum(&params.ChecksumCRC32C, &params.ChecksumSHA256, algorithm, sum)
+				mu.Lock()
+				parts[*params.PartNumber] = sum
+				mu.Unlock()
+			case *s3.CompleteMultipartUploadInput:
+				if params.MultipartUpload == nil {
+					return
+				}
+				mu.Lock()
+				for _, part := range params.MultipartUpload.Parts {
+					setChecksum(&part.ChecksumCRC32C, &part.ChecksumSHA256, algorithm, parts[*part.PartNumber])
+				}
+				mu.Unlock()
+			}
+		})
+	}
+}
+
+// bodyChecksum return encoded checksum of the request body, the body is seeked back to the start position.
+func bodyChecksum(body io.ReadSeeker, algorithm string) (*string, error) {
+	h, err := storage.NewChecksumHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		sum := storage.EncodeChecksum(h.Sum(nil))
+		return &sum, nil
+	}
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, body); err != nil {
+		return nil, err
+	}
+	if _, err := body.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sum := storage.EncodeChecksum(h.Sum(nil))
+	return &sum, nil
+}
+
+func setChecksum(crc32c, sha256 **string, algorithm string, sum *string) {
+	switch algorithm {
+	case storage.ChecksumCRC32C:
+		*crc32c = sum
+	case storage.ChecksumSHA256:
+		*sha256 = sum
+	}
+}
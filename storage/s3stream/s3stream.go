@@ -32,6 +32,9 @@ type S3StreamStorage struct {
 	ctx           context.Context
 	listMarker    *string
 	rlBucket      ratelimit.Bucket
+	sse           s3backend.SSEConfig
+	checksums     bool
+	uploadSum     string
 	uploader      *s3manager.Uploader
 }
 
@@ -100,6 +103,22 @@ func (st *S3StreamStorage) WithRateLimit(limit int) error {
 	return nil
 }
 
+// WithSSE set server-side encryption settings for storage.
+func (st *S3StreamStorage) WithSSE(cfg s3backend.SSEConfig) {
+	st.sse = cfg
+}
+
+// WithChecksums enable reading of object checksums from S3 (x-amz-checksum-mode).
+func (st *S3StreamStorage) WithChecksums(enable bool) {
+	st.checksums = enable
+}
+
+// WithUploadChecksum enable sending of content checksums of given algorithm (see storage.ChecksumAlgorithms) on upload.
+// Checksums are computed for each uploaded part, so S3 verifies them for streamed multipart uploads too.
+func (st *S3StreamStorage) WithUploadChecksum(algorithm string) {
+	st.uploadSum = algorithm
+}
+
 // List S3 bucket and send founded objects to chan.
 func (st *S3StreamStorage) List(output chan<- *storage.Object) error {
 	listObjectsFn := func(p *s3.ListObjectsOutput, lastPage bool) bool {
@@ -107,11 +126,12 @@ func (st *S3StreamStorage) List(output chan<- *storage.Object) error {
 			key, _ := url.QueryUnescape(aws.StringValue(o.Key))
 			key = strings.Replace(key, st.prefix, "", 1)
 			output <- &storage.Object{
-				Key:          &key,
-				ETag:         storage.StrongEtag(o.ETag),
-				Mtime:        o.LastModified,
-				StorageClass: o.StorageClass,
-				IsLatest:     aws.Bool(true),
+				Key:           &key,
+				ETag:          storage.StrongEtag(o.ETag),
+				Mtime:         o.LastModified,
+				ContentLength: o.Size,
+				StorageClass:  o.StorageClass,
+				IsLatest:      aws.Bool(true),
 			}
 		}
 		st.listMarker = p.Marker
@@ -134,9 +154,36 @@ func (st *S3StreamStorage) List(output chan<- *storage.Object) error {
 
 }
 
+// ListParallel list S3 bucket with concurrent requests by top level dirs and send founded objects to chan, see s3.ListParallel func.
+func (st *S3StreamStorage) ListParallel(output chan<- *storage.Object, workers int) error {
+	listPages := func(prefix, delimiter string, fn func(objects []*s3.Object, prefixes []*s3.CommonPrefix)) error {
+		input := &s3.ListObjectsInput{
+			Bucket:       st.awsBucket,
+			Prefix:       aws.String(prefix),
+			MaxKeys:      aws.Int64(st.keysPerReq),
+			EncodingType: aws.String(s3.EncodingTypeUrl),
+		}
+		if delimiter != "" {
+			input.Delimiter = aws.String(delimiter)
+		}
+		return st.awsSvc.ListObjectsPagesWithContext(st.ctx, input, func(p *s3.ListObjectsOutput, lastPage bool) bool {
+			fn(p.Contents, p.CommonPrefixes)
+			return !lastPage
+		})
+	}
+	if err := s3backend.ListParallel(listPages, st.prefix, workers, output); err != nil {
+		return err
+	}
+	storage.Log.Debugf("Listing bucket finished")
+	return nil
+}
+
 // PutObject saves object to S3.
 // PutObject ignore VersionId, it always save object as latest version.
 func (st *S3StreamStorage) PutObject(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
 	// Check which input format to use, prefer stream, add fallback to buffer
 	var readStream io.Reader
 	if obj.ContentStream == nil {
@@ -151,22 +198,44 @@ func (st *S3StreamStorage) PutObject(obj *storage.Object) error {
 	}
 
 	rlReader := ratelimit.NewReader(readStream, st.rlBucket)
+	// Uploader ignores checksums for multipart uploads, and checksums of content stream are known only
+	// after it is read, so they are sent only if known in advance and the object fits in a single part.
+	// Otherwise checksums of each part are sent if upload checksum is enabled, see WithUploadChecksum.
+	checksumCRC32C, checksumSHA256 := s3backend.ChecksumHeaders(obj)
 	input := &s3manager.UploadInput{
-		Bucket:               st.awsBucket,
-		Key:                  aws.String(st.prefix + *obj.Key),
-		Body:                 rlReader,
-		ContentType:          obj.ContentType,
-		ContentDisposition:   obj.ContentDisposition,
-		ContentEncoding:      obj.ContentEncoding,
-		ContentLanguage:      obj.ContentLanguage,
-		ACL:                  obj.ACL,
-		Metadata:             obj.Metadata,
-		CacheControl:         obj.CacheControl,
-		StorageClass:         obj.StorageClass,
-		ServerSideEncryption: obj.ServerSideEncryption,
-	}
-
-	if _, err := st.uploader.UploadWithContext(st.ctx, input); err != nil {
+		Bucket:                    st.awsBucket,
+		Key:                       aws.String(st.prefix + *obj.Key),
+		Body:                      rlReader,
+		ContentType:               obj.ContentType,
+		ContentDisposition:        obj.ContentDisposition,
+		ContentEncoding:           obj.ContentEncoding,
+		ContentLanguage:           obj.ContentLanguage,
+		ACL:                       obj.ACL,
+		Metadata:                  obj.Metadata,
+		CacheControl:              obj.CacheControl,
+		StorageClass:              obj.StorageClass,
+		ServerSideEncryption:      st.sse.ServerSideEncryption(obj.ServerSideEncryption),
+		SSEKMSKeyId:               st.sse.KMSKeyId,
+		BucketKeyEnabled:          st.sse.BucketKeyEnabled,
+		SSECustomerAlgorithm:      st.sse.CustomerAlgorithm(),
+		SSECustomerKey:            st.sse.CustomerKey,
+		Tagging:                   s3backend.EncodeTags(obj.Tags),
+		ObjectLockMode:            obj.ObjectLockMode,
+		ObjectLockRetainUntilDate: obj.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: obj.ObjectLockLegalHoldStatus,
+		ChecksumCRC32C:            checksumCRC32C,
+		ChecksumSHA256:            checksumSHA256,
+	}
+
+	var uploadOpts []func(*s3manager.Uploader)
+	if st.uploadSum != "" {
+		input.ChecksumAlgorithm = aws.String(st.uploadSum)
+		uploadOpts = append(uploadOpts, func(u *s3manager.Uploader) {
+			u.RequestOptions = append(u.RequestOptions[:len(u.RequestOptions):len(u.RequestOptions)], uploadChecksumOption(st.uploadSum))
+		})
+	}
+
+	if _, err := st.uploader.UploadWithContext(ctx, input, uploadOpts...); err != nil {
 		return err
 	}
 
@@ -177,7 +246,7 @@ func (st *S3StreamStorage) PutObject(obj *storage.Object) error {
 			AccessControlPolicy: obj.AccessControlPolicy,
 		}
 
-		if _, err := st.awsSvc.PutObjectAclWithContext(st.ctx, inputAcl); err != nil {
+		if _, err := st.awsSvc.PutObjectAclWithContext(ctx, inputAcl); err != nil {
 			return err
 		}
 	}
@@ -187,19 +256,25 @@ func (st *S3StreamStorage) PutObject(obj *storage.Object) error {
 
 // GetObjectContent read object content and metadata from S3.
 func (st *S3StreamStorage) GetObjectContent(obj *storage.Object) error {
+	sc := storage.NewStreamContext(st.ctx, obj)
 	input := &s3.GetObjectInput{
-		Bucket:    st.awsBucket,
-		Key:       aws.String(st.prefix + *obj.Key),
-		VersionId: obj.VersionId,
+		Bucket:               st.awsBucket,
+		Key:                  aws.String(st.prefix + *obj.Key),
+		VersionId:            obj.VersionId,
+		SSECustomerAlgorithm: st.sse.CustomerAlgorithm(),
+		SSECustomerKey:       st.sse.CustomerKey,
+		ChecksumMode:         s3backend.ChecksumMode(st.checksums),
 	}
 
-	result, err := st.awsSvc.GetObjectWithContext(st.ctx, input)
+	result, err := st.awsSvc.GetObjectWithContext(sc.Context(), input)
 	if err != nil {
+		sc.Cancel()
 		return err
 	}
 
 	obj.Content = nil
-	obj.ContentStream = result.Body
+	// The content stream is read by the next steps, so it has read timeout instead of the object deadline.
+	obj.ContentStream = sc.ReadCloser(result.Body)
 	obj.ContentType = result.ContentType
 	obj.ContentLength = result.ContentLength
 	obj.ContentDisposition = result.ContentDisposition
@@ -208,21 +283,27 @@ func (st *S3StreamStorage) GetObjectContent(obj *storage.Object) error {
 	obj.ETag = storage.StrongEtag(result.ETag)
 	obj.Metadata = result.Metadata
 	obj.Mtime = result.LastModified
+	obj.ContentLength = result.ContentLength
 	obj.CacheControl = result.CacheControl
 	obj.StorageClass = result.StorageClass
+	obj.ChecksumCRC32C = storage.FullObjectChecksum(result.ChecksumCRC32C)
+	obj.ChecksumSHA256 = storage.FullObjectChecksum(result.ChecksumSHA256)
 
 	return nil
 }
 
 // GetObjectACL read object ACL from S3.
 func (st *S3StreamStorage) GetObjectACL(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
 	input := &s3.GetObjectAclInput{
 		Bucket:    st.awsBucket,
 		Key:       aws.String(st.prefix + *obj.Key),
 		VersionId: obj.VersionId,
 	}
 
-	result, err := st.awsSvc.GetObjectAclWithContext(st.ctx, input)
+	result, err := st.awsSvc.GetObjectAclWithContext(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -235,15 +316,79 @@ func (st *S3StreamStorage) GetObjectACL(obj *storage.Object) error {
 	return nil
 }
 
-// GetObjectMeta update object metadata from S3.
-func (st *S3StreamStorage) GetObjectMeta(obj *storage.Object) error {
-	input := &s3.HeadObjectInput{
+// GetObjectTags read object tags from S3.
+func (st *S3StreamStorage) GetObjectTags(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
+	input := &s3.GetObjectTaggingInput{
 		Bucket:    st.awsBucket,
 		Key:       aws.String(st.prefix + *obj.Key),
 		VersionId: obj.VersionId,
 	}
 
-	result, err := st.awsSvc.HeadObjectWithContext(st.ctx, input)
+	result, err := st.awsSvc.GetObjectTaggingWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	obj.Tags = s3backend.DecodeTags(result.TagSet)
+
+	return nil
+}
+
+// GetObjectLock read object retention and legal hold from S3.
+func (st *S3StreamStorage) GetObjectLock(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
+	inputRetention := &s3.GetObjectRetentionInput{
+		Bucket:    st.awsBucket,
+		Key:       aws.String(st.prefix + *obj.Key),
+		VersionId: obj.VersionId,
+	}
+
+	retention, err := st.awsSvc.GetObjectRetentionWithContext(ctx, inputRetention)
+	if err != nil && !s3backend.IsErrNoObjectLockConfiguration(err) {
+		return err
+	}
+	if err == nil && retention.Retention != nil {
+		obj.ObjectLockMode = retention.Retention.Mode
+		obj.ObjectLockRetainUntilDate = retention.Retention.RetainUntilDate
+	}
+
+	inputLegalHold := &s3.GetObjectLegalHoldInput{
+		Bucket:    st.awsBucket,
+		Key:       aws.String(st.prefix + *obj.Key),
+		VersionId: obj.VersionId,
+	}
+
+	legalHold, err := st.awsSvc.GetObjectLegalHoldWithContext(ctx, inputLegalHold)
+	if err != nil && !s3backend.IsErrNoObjectLockConfiguration(err) {
+		return err
+	}
+	if err == nil && legalHold.LegalHold != nil {
+		obj.ObjectLockLegalHoldStatus = legalHold.LegalHold.Status
+	}
+
+	return nil
+}
+
+// GetObjectMeta update object metadata from S3.
+func (st *S3StreamStorage) GetObjectMeta(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
+	input := &s3.HeadObjectInput{
+		Bucket:               st.awsBucket,
+		Key:                  aws.String(st.prefix + *obj.Key),
+		VersionId:            obj.VersionId,
+		SSECustomerAlgorithm: st.sse.CustomerAlgorithm(),
+		SSECustomerKey:       st.sse.CustomerKey,
+		ChecksumMode:         s3backend.ChecksumMode(st.checksums),
+	}
+
+	result, err := st.awsSvc.HeadObjectWithContext(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -255,21 +400,27 @@ func (st *S3StreamStorage) GetObjectMeta(obj *storage.Object) error {
 	obj.ETag = storage.StrongEtag(result.ETag)
 	obj.Metadata = result.Metadata
 	obj.Mtime = result.LastModified
+	obj.ContentLength = result.ContentLength
 	obj.CacheControl = result.CacheControl
 	obj.StorageClass = result.StorageClass
+	obj.ChecksumCRC32C = storage.FullObjectChecksum(result.ChecksumCRC32C)
+	obj.ChecksumSHA256 = storage.FullObjectChecksum(result.ChecksumSHA256)
 
 	return nil
 }
 
 // DeleteObject remove object from S3.
 func (st *S3StreamStorage) DeleteObject(obj *storage.Object) error {
+	ctx, cancel := storage.ObjectContext(st.ctx, obj)
+	defer cancel()
+
 	input := &s3.DeleteObjectInput{
 		Bucket:    st.awsBucket,
 		Key:       aws.String(st.prefix + *obj.Key),
 		VersionId: obj.VersionId,
 	}
 
-	if _, err := st.awsSvc.DeleteObjectWithContext(st.ctx, input); err != nil {
+	if _, err := st.awsSvc.DeleteObjectWithContext(ctx, input); err != nil {
 		return err
 	}
 	return nil
@@ -27,27 +27,40 @@ const (
 
 // Object contain content and metadata of S3 object.
 type Object struct {
-	Key                  *string                 `json:"-"`
-	ETag                 *string                 `json:"e_tag"`
-	Mtime                *time.Time              `json:"mtime"`
-	Content              *[]byte                 `json:"-"`
-	ContentStream        io.ReadCloser           `json:"-"`
-	ContentLength        *int64                  `json:"-"`
-	ContentType          *string                 `json:"content_type"`
-	ContentDisposition   *string                 `json:"content_disposition"`
-	ContentEncoding      *string                 `json:"content_encoding"`
-	ContentLanguage      *string                 `json:"content_language"`
-	Metadata             map[string]*string      `json:"metadata"`
-	ACL                  *string                 `json:"acl"`
-	CacheControl         *string                 `json:"cache_control"`
-	VersionId            *string                 `json:"version_id"`
-	IsLatest             *bool                   `json:"-"`
-	StorageClass         *string                 `json:"storage_class"`
-	AccessControlPolicy  *s3.AccessControlPolicy `json:"access_control_policy"`
-	ServerSideEncryption *string                 `json:"server_side_encryption"`
+	Key                       *string                 `json:"-"`
+	OriginalKey               *string                 `json:"-"`
+	ETag                      *string                 `json:"e_tag"`
+	Mtime                     *time.Time              `json:"mtime"`
+	Content                   *[]byte                 `json:"-"`
+	ContentStream             io.ReadCloser           `json:"-"`
+	ContentLength             *int64                  `json:"-"`
+	ContentType               *string                 `json:"content_type"`
+	ContentDisposition        *string                 `json:"content_disposition"`
+	ContentEncoding           *string                 `json:"content_encoding"`
+	ContentLanguage           *string                 `json:"content_language"`
+	Metadata                  map[string]*string      `json:"metadata"`
+	ACL                       *string                 `json:"acl"`
+	CacheControl              *string                 `json:"cache_control"`
+	VersionId                 *string                 `json:"version_id"`
+	IsLatest                  *bool                   `json:"-"`
+	StorageClass              *string                 `json:"storage_class"`
+	AccessControlPolicy       *s3.AccessControlPolicy `json:"access_control_policy"`
+	ServerSideEncryption      *string                 `json:"server_side_encryption"`
+	Tags                      map[string]*string      `json:"tags"`
+	ObjectLockMode            *string                 `json:"object_lock_mode"`
+	ObjectLockRetainUntilDate *time.Time              `json:"object_lock_retain_until_date"`
+	ObjectLockLegalHoldStatus *string                 `json:"object_lock_legal_hold_status"`
+	ChecksumCRC32C            *string                 `json:"-"`
+	ChecksumSHA256            *string                 `json:"-"`
+	// Origin is the name of the source storage the object is listed from, empty for the main source.
+	Origin string `json:"-"`
+	// Deadline of storage calls with the object, see ObjectContext. Zero means no deadline.
+	Deadline time.Time `json:"-"`
 }
 
 // Storage interface.
+// S3 storages respect Object.Deadline in calls with an object, see ObjectContext.
+// FS and Swift storages use only the storage context.
 type Storage interface {
 	WithContext(ctx context.Context)
 	WithRateLimit(limit int) error
@@ -56,5 +69,96 @@ type Storage interface {
 	GetObjectContent(obj *Object) error
 	GetObjectMeta(obj *Object) error
 	GetObjectACL(obj *Object) error
+	GetObjectTags(obj *Object) error
+	GetObjectLock(obj *Object) error
 	DeleteObject(obj *Object) error
 }
+
+// ParallelLister is implemented by storages that can list objects with several concurrent requests.
+// It is used for large listings, like target listing of filters, see collection.TargetIndex.
+// Unlike Storage.List, the listing order is not defined.
+type ParallelLister interface {
+	ListParallel(ch chan<- *Object, workers int) error
+}
+
+// ObjectContext return the context of storage calls with the object: ctx with Object.Deadline if it is set.
+// The cancel func should be called when the call is finished.
+// Calls that open the content stream should use StreamContext, because the stream is read by the next steps.
+func ObjectContext(ctx context.Context, obj *Object) (context.Context, context.CancelFunc) {
+	if obj.Deadline.IsZero() {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, obj.Deadline)
+}
+
+// minStreamReadTimeout is the minimal timeout of one Read of the stream opened with StreamContext,
+// so streams opened close to Object.Deadline are not cancelled on the first Read.
+const minStreamReadTimeout = time.Second
+
+// StreamContext is the context of storage call that open the content stream of the object.
+// The call is cancelled if it is not finished before Object.Deadline. The opened stream is not bound to the deadline,
+// because it is read by the next steps, it is cancelled only if one read of the stream is not finished
+// within the object timeout (the time from the call start to Object.Deadline, at least minStreamReadTimeout), see ReadCloser.
+type StreamContext struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// NewStreamContext return the StreamContext of storage call with the object.
+func NewStreamContext(ctx context.Context, obj *Object) *StreamContext {
+	sc := &StreamContext{}
+	sc.ctx, sc.cancel = context.WithCancel(ctx)
+	if !obj.Deadline.IsZero() {
+		sc.timer = time.AfterFunc(time.Until(obj.Deadline), sc.cancel)
+		sc.timeout = time.Until(obj.Deadline)
+		if sc.timeout < minStreamReadTimeout {
+			sc.timeout = minStreamReadTimeout
+		}
+	}
+	return sc
+}
+
+// Context return the context of the call.
+func (sc *StreamContext) Context() context.Context {
+	return sc.ctx
+}
+
+// Cancel release the context, it should be called if the call failed.
+func (sc *StreamContext) Cancel() {
+	if sc.timer != nil {
+		sc.timer.Stop()
+	}
+	sc.cancel()
+}
+
+// ReadCloser return the stream opened by the call, which is cancelled if one Read is not finished within
+// the object timeout. The context is released when the stream is closed.
+func (sc *StreamContext) ReadCloser(rc io.ReadCloser) io.ReadCloser {
+	if sc.timer != nil {
+		sc.timer.Stop()
+	}
+	return &streamReadCloser{ReadCloser: rc, sc: sc}
+}
+
+// streamReadCloser arm the timer of StreamContext for each Read and cancel the context on Close.
+type streamReadCloser struct {
+	io.ReadCloser
+	sc *StreamContext
+}
+
+// Read the stream. The stream context is cancelled if the read is not finished within the object timeout.
+func (rc *streamReadCloser) Read(p []byte) (int, error) {
+	if rc.sc.timer != nil {
+		rc.sc.timer.Reset(rc.sc.timeout)
+		defer rc.sc.timer.Stop()
+	}
+	return rc.ReadCloser.Read(p)
+}
+
+// Close the stream and cancel its context.
+func (rc *streamReadCloser) Close() error {
+	defer rc.sc.Cancel()
+	return rc.ReadCloser.Close()
+}
@@ -191,6 +191,16 @@ func (st *Storage) GetObjectACL(obj *storage.Object) error {
 	return nil
 }
 
+// GetObjectTags do nothing, Swift does not support object tags.
+func (st *Storage) GetObjectTags(obj *storage.Object) error {
+	return nil
+}
+
+// GetObjectLock do nothing, Swift does not support object lock.
+func (st *Storage) GetObjectLock(obj *storage.Object) error {
+	return nil
+}
+
 // GetObjectMeta update object metadata from S3.
 func (st *Storage) GetObjectMeta(obj *storage.Object) error {
 	opts := objects.GetOpts{}
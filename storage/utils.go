@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,6 +31,7 @@ func (f *ErrHandlingMask) Add(flag ErrHandlingMask) { *f |= flag }
 const (
 	HandleErrNotExist ErrHandlingMask = 1 << iota
 	HandleErrPermission
+	HandleErrChecksum
 	HandleErrOther = 64
 )
 
@@ -69,3 +72,65 @@ func ToValue[K any](val *K) K {
 	}
 	return *val
 }
+
+// MetaMtime is the metadata key of the original object modification time (unix timestamp).
+// It is stored by FS storage with POSIX attributes preserving enabled.
+const MetaMtime = "mtime"
+
+// GetMetadata return value of object user metadata key.
+// Keys are compared case-insensitively, because S3 may return metadata keys in canonical header format.
+func GetMetadata(obj *Object, key string) (string, bool) {
+	for k, v := range obj.Metadata {
+		if strings.EqualFold(k, key) {
+			return ToValue(v), true
+		}
+	}
+	return "", false
+}
+
+// SetMetadata set value of object user metadata key, replacing existing key in any case.
+func SetMetadata(obj *Object, key, value string) {
+	DeleteMetadata(obj, key)
+	if obj.Metadata == nil {
+		obj.Metadata = make(map[string]*string)
+	}
+	obj.Metadata[key] = &value
+}
+
+// DeleteMetadata remove object user metadata key in any case.
+func DeleteMetadata(obj *Object, key string) {
+	for k := range obj.Metadata {
+		if strings.EqualFold(k, key) {
+			delete(obj.Metadata, k)
+		}
+	}
+}
+
+// FormatUnixTime format time as unix timestamp with fractional part if it is not zero.
+func FormatUnixTime(t time.Time) string {
+	if t.Nanosecond() == 0 {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// ParseUnixTime parse unix timestamp with optional fractional part.
+func ParseUnixTime(s string) (time.Time, error) {
+	parts := strings.SplitN(s, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if len(parts) == 2 && parts[1] != "" {
+		frac := parts[1]
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}